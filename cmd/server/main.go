@@ -4,11 +4,15 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 	"github.com/rs/cors"
 
+	"synctunes/internal/account"
+	"synctunes/internal/auth"
 	"synctunes/internal/handlers"
 	"synctunes/internal/music"
 	"synctunes/internal/room"
@@ -31,21 +35,118 @@ func main() {
 		musicDir = "./music"
 	}
 
+	// Parsed track metadata is cached here between restarts
+	indexPath := os.Getenv("CATALOG_INDEX")
+	if indexPath == "" {
+		indexPath = "./data/catalog.gob"
+	}
+
 	// Ensure music directory exists
 	if err := os.MkdirAll(musicDir, 0755); err != nil {
 		log.Fatal("Failed to create music directory:", err)
 	}
 
 	// Initialize services
-	musicService := music.NewService(musicDir)
+	musicService := music.NewService(musicDir, indexPath)
 	roomManager := room.NewManager()
-	wsHub := websocket.NewHub(roomManager)
+	wsHub := websocket.NewHub(roomManager, musicService)
+
+	// Who takes over a room when its host has been away for too long
+	succession := room.DefaultSuccession
+	if policy := os.Getenv("HOST_SUCCESSION"); policy != "" {
+		successionPolicy, err := room.ParseSuccessionPolicy(policy)
+		if err != nil {
+			log.Fatal("Invalid HOST_SUCCESSION:", err)
+		}
+		succession.Policy = successionPolicy
+	}
+	if after := os.Getenv("HOST_SUCCESSION_AFTER"); after != "" {
+		successionAfter, err := time.ParseDuration(after)
+		if err != nil || successionAfter <= 0 {
+			log.Fatal("Invalid HOST_SUCCESSION_AFTER:", after)
+		}
+		succession.After = successionAfter
+	}
+	roomManager.SetSuccession(succession)
+
+	// Rooms close once nobody has been in them for a while, and in any
+	// case once they are old enough; 0 turns either limit off
+	lifetime := room.DefaultLifetime
+	for name, limit := range map[string]*time.Duration{
+		"ROOM_IDLE_TIMEOUT": &lifetime.Idle,
+		"ROOM_MAX_AGE":      &lifetime.MaxAge,
+	} {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				log.Fatalf("Invalid %s: %s", name, value)
+			}
+			*limit = d
+		}
+	}
+
+	// What to do with clients that can't keep up with room updates
+	if policy := os.Getenv("SLOW_CLIENT_POLICY"); policy != "" {
+		slowClientPolicy, err := websocket.ParseSlowClientPolicy(policy)
+		if err != nil {
+			log.Fatal("Invalid SLOW_CLIENT_POLICY:", err)
+		}
+		wsHub.SetSlowClientPolicy(slowClientPolicy)
+	}
+
+	// Pages on other sites that may open websockets, beyond our own
+	if origins := os.Getenv("ALLOWED_ORIGINS"); origins != "" {
+		wsHub.SetAllowedOrigins(strings.Split(origins, ","))
+	}
+
+	// Session tokens are signed with this key. Without one, a random key is
+	// used and sessions end with the process.
+	sessionSecret := []byte(os.Getenv("SESSION_SECRET"))
+	if len(sessionSecret) == 0 {
+		secret, err := auth.RandomSecret()
+		if err != nil {
+			log.Fatal("Failed to create session secret:", err)
+		}
+		sessionSecret = secret
+	}
+	sessions := auth.NewSessions(sessionSecret)
+
+	// Accounts are optional; anyone can still join a room with just a name
+	var accounts *account.Service
+	storeKind := os.Getenv("ACCOUNT_STORE")
+	if storeKind == "" {
+		storeKind = "file"
+	}
+	if storeKind != "none" {
+		storePath := os.Getenv("ACCOUNT_PATH")
+		if storePath == "" {
+			storePath = "./data/accounts.json"
+			if storeKind == "bolt" {
+				storePath = "./data/accounts.db"
+			}
+		}
+		store, err := account.Open(storeKind, storePath)
+		if err != nil {
+			log.Fatal("Failed to open account store:", err)
+		}
+		accounts = account.NewService(store)
+		log.Printf("Accounts: %s store at %s", storeKind, storePath)
+	}
 
 	// Start WebSocket hub
 	go wsHub.Run()
 
+	// Watch the music directory and push catalog changes to hosts
+	musicService.OnChange(wsHub.BroadcastCatalogChange)
+	if err := musicService.Watch(); err != nil {
+		log.Printf("Failed to watch music directory: %v", err)
+	}
+
 	// Initialize handlers
-	h := handlers.New(musicService, roomManager, wsHub)
+	h := handlers.New(musicService, roomManager, wsHub, sessions, accounts)
+
+	// Start closing expired rooms once their clients can be told
+	roomManager.StartJanitor(lifetime)
 
 	// Setup routes
 	r := mux.NewRouter()
@@ -53,14 +154,38 @@ func main() {
 	// API routes
 	api := r.PathPrefix("/api").Subrouter()
 	api.HandleFunc("/music/catalog", h.GetMusicCatalog).Methods("GET")
-	api.HandleFunc("/music/stream/{id}", h.StreamMusic).Methods("GET")
+	api.HandleFunc("/music/search", h.SearchMusic).Methods("GET")
+	api.HandleFunc("/music/artists", h.GetArtists).Methods("GET")
+	api.HandleFunc("/music/artists/{id}", h.GetArtist).Methods("GET")
+	api.HandleFunc("/music/albums", h.GetAlbums).Methods("GET")
+	api.HandleFunc("/music/albums/{id}", h.GetAlbum).Methods("GET")
+	api.HandleFunc("/music/art/{id:.+}", h.GetArtwork).Methods("GET")
+	api.HandleFunc("/music/status", h.GetMusicStatus).Methods("GET")
+	// Track IDs used to be file paths, so old links may contain slashes
+	api.HandleFunc("/music/stream/{id:.+}", h.StreamMusic).Methods("GET", "HEAD")
+	api.HandleFunc("/ws/status", h.GetHubStatus).Methods("GET")
+	api.HandleFunc("/accounts", h.Register).Methods("POST")
+	api.HandleFunc("/login", h.Login).Methods("POST")
+	api.HandleFunc("/logout", h.Logout).Methods("POST")
+	api.HandleFunc("/me", h.GetMe).Methods("GET")
+	api.HandleFunc("/me", h.UpdateMe).Methods("PATCH")
 	api.HandleFunc("/rooms", h.CreateRoom).Methods("POST")
 	api.HandleFunc("/rooms/{id}", h.GetRoom).Methods("GET")
+	api.HandleFunc("/rooms/{id}", h.DeleteRoom).Methods("DELETE")
 	api.HandleFunc("/rooms/{id}/join", h.JoinRoom).Methods("POST")
 	api.HandleFunc("/rooms/{id}/play", h.PlayTrack).Methods("POST")
 	api.HandleFunc("/rooms/{id}/pause", h.PauseRoom).Methods("POST")
 	api.HandleFunc("/rooms/{id}/resume", h.ResumeRoom).Methods("POST")
 	api.HandleFunc("/rooms/{id}/seek", h.SeekTrack).Methods("POST")
+	api.HandleFunc("/rooms/{id}/mode", h.SetPlaybackMode).Methods("POST")
+	api.HandleFunc("/rooms/{id}/transfer-host", h.TransferHost).Methods("POST")
+	api.HandleFunc("/rooms/{id}/users/{userId}", h.KickUser).Methods("DELETE")
+	api.HandleFunc("/rooms/{id}/users/{userId}/role", h.SetUserRole).Methods("PUT")
+	api.HandleFunc("/rooms/{id}/queue", h.GetQueue).Methods("GET")
+	api.HandleFunc("/rooms/{id}/queue", h.EnqueueTrack).Methods("POST")
+	api.HandleFunc("/rooms/{id}/queue", h.ClearQueue).Methods("DELETE")
+	api.HandleFunc("/rooms/{id}/queue/{itemId}", h.RemoveQueueItem).Methods("DELETE")
+	api.HandleFunc("/rooms/{id}/queue/{itemId}/move", h.MoveQueueItem).Methods("POST")
 
 	// WebSocket endpoint
 	r.HandleFunc("/ws/{roomId}", h.HandleWebSocket)
@@ -76,7 +201,7 @@ func main() {
 	// CORS
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{"*"},
 	})
 
@@ -84,5 +209,6 @@ func main() {
 
 	log.Printf("Server starting on port %s", port)
 	log.Printf("Music directory: %s", musicDir)
+	log.Printf("Catalog index: %s", indexPath)
 	log.Fatal(http.ListenAndServe(":"+port, handler))
 }
@@ -0,0 +1,181 @@
+// Package account keeps the optional user accounts that let people sign in
+// with a username and password, so they are the same person in every room
+// and every session.
+package account
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength    = 8
+	maxPasswordLength    = 72 // bcrypt ignores anything longer
+	maxDisplayNameLength = 64
+)
+
+var (
+	ErrNotFound        = errors.New("account not found")
+	ErrUsernameTaken   = errors.New("username is taken")
+	ErrWrongPassword   = errors.New("wrong username or password")
+	ErrInvalidUsername = errors.New("usernames are 3 to 32 letters, digits, dots, dashes or underscores")
+	ErrInvalidPassword = fmt.Errorf("passwords are %d to %d bytes long", minPasswordLength, maxPasswordLength)
+	ErrInvalidName     = fmt.Errorf("display names are 1 to %d characters long", maxDisplayNameLength)
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9._-]{3,32}$`)
+
+type Account struct {
+	ID           string    `json:"id"`
+	Username     string    `json:"username"`
+	DisplayName  string    `json:"display_name"`
+	PasswordHash []byte    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Profile is what an account shows the world, without its password hash.
+type Profile struct {
+	ID          string    `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (a *Account) Profile() Profile {
+	return Profile{
+		ID:          a.ID,
+		Username:    a.Username,
+		DisplayName: a.DisplayName,
+		CreatedAt:   a.CreatedAt,
+	}
+}
+
+// Store keeps accounts. Usernames are unique, and stores look them up
+// exactly as given; the Service normalises them first.
+type Store interface {
+	Get(id string) (*Account, error)
+	GetByUsername(username string) (*Account, error)
+	// Create adds a new account, or fails with ErrUsernameTaken.
+	Create(account *Account) error
+	// Update replaces an existing account. The username can't change.
+	Update(account *Account) error
+	Close() error
+}
+
+// Open opens the kind of store named, "file" or "bolt", at path.
+func Open(kind, path string) (Store, error) {
+	switch kind {
+	case "file":
+		return OpenFileStore(path)
+	case "bolt":
+		return OpenBoltStore(path)
+	}
+	return nil, fmt.Errorf("unknown account store %q", kind)
+}
+
+// Service registers and signs in accounts.
+type Service struct {
+	store Store
+
+	// dummyHash is checked against when a username doesn't exist, so
+	// signing in takes as long either way
+	dummyHash []byte
+}
+
+func NewService(store Store) *Service {
+	dummyHash, _ := bcrypt.GenerateFromPassword([]byte("synctunes"), bcrypt.DefaultCost)
+	return &Service{store: store, dummyHash: dummyHash}
+}
+
+// Register creates an account. The display name defaults to the username.
+func (s *Service) Register(username, password, displayName string) (*Account, error) {
+	username = normaliseUsername(username)
+	if !usernamePattern.MatchString(username) {
+		return nil, ErrInvalidUsername
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return nil, ErrInvalidPassword
+	}
+	if displayName = strings.TrimSpace(displayName); displayName == "" {
+		displayName = username
+	}
+	if !validDisplayName(displayName) {
+		return nil, ErrInvalidName
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("hashing password: %w", err)
+	}
+
+	account := &Account{
+		ID:           uuid.New().String(),
+		Username:     username,
+		DisplayName:  displayName,
+		PasswordHash: hash,
+		CreatedAt:    time.Now(),
+	}
+	if err := s.store.Create(account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// Authenticate returns the account a username and password belong to.
+func (s *Service) Authenticate(username, password string) (*Account, error) {
+	account, err := s.store.GetByUsername(normaliseUsername(username))
+	if errors.Is(err, ErrNotFound) {
+		bcrypt.CompareHashAndPassword(s.dummyHash, []byte(password))
+		return nil, ErrWrongPassword
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword(account.PasswordHash, []byte(password)); err != nil {
+		return nil, ErrWrongPassword
+	}
+	return account, nil
+}
+
+func (s *Service) Get(id string) (*Account, error) {
+	return s.store.Get(id)
+}
+
+// SetDisplayName changes the name an account goes by in rooms.
+func (s *Service) SetDisplayName(id, displayName string) (*Account, error) {
+	displayName = strings.TrimSpace(displayName)
+	if !validDisplayName(displayName) {
+		return nil, ErrInvalidName
+	}
+
+	account, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	account.DisplayName = displayName
+	if err := s.store.Update(account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (s *Service) Close() error {
+	return s.store.Close()
+}
+
+func normaliseUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func validDisplayName(name string) bool {
+	n := utf8.RuneCountInString(name)
+	return n > 0 && n <= maxDisplayNameLength
+}
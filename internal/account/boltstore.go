@@ -0,0 +1,119 @@
+package account
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	accountsBucket  = []byte("accounts")  // account ID to JSON account
+	usernamesBucket = []byte("usernames") // username to account ID
+)
+
+// BoltStore keeps accounts in an embedded bbolt database, for servers with
+// more accounts than fit comfortably in one file.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func OpenBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// Another server holding the database would block us forever
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening account database %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(accountsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(usernamesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Get(id string) (*Account, error) {
+	var account *Account
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		account, err = getAccount(tx, []byte(id))
+		return err
+	})
+	return account, err
+}
+
+func (s *BoltStore) GetByUsername(username string) (*Account, error) {
+	var account *Account
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(usernamesBucket).Get([]byte(username))
+		if id == nil {
+			return ErrNotFound
+		}
+		var err error
+		account, err = getAccount(tx, id)
+		return err
+	})
+	return account, err
+}
+
+func (s *BoltStore) Create(account *Account) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		usernames := tx.Bucket(usernamesBucket)
+		if usernames.Get([]byte(account.Username)) != nil {
+			return ErrUsernameTaken
+		}
+		if err := usernames.Put([]byte(account.Username), []byte(account.ID)); err != nil {
+			return err
+		}
+		return putAccount(tx, account)
+	})
+}
+
+func (s *BoltStore) Update(account *Account) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		old, err := getAccount(tx, []byte(account.ID))
+		if err != nil {
+			return err
+		}
+		updated := *account
+		updated.Username = old.Username
+		return putAccount(tx, &updated)
+	})
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func getAccount(tx *bolt.Tx, id []byte) (*Account, error) {
+	data := tx.Bucket(accountsBucket).Get(id)
+	if data == nil {
+		return nil, ErrNotFound
+	}
+	var account Account
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("reading account %s: %w", id, err)
+	}
+	return &account, nil
+}
+
+func putAccount(tx *bolt.Tx, account *Account) error {
+	data, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(accountsBucket).Put([]byte(account.ID), data)
+}
@@ -0,0 +1,152 @@
+package account
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore keeps every account in memory and in a single JSON file,
+// which is rewritten on each change. It suits the handful of accounts a
+// self-hosted server has.
+type FileStore struct {
+	path     string
+	mu       sync.RWMutex
+	accounts map[string]*Account // by ID
+}
+
+// OpenFileStore loads the accounts in the file at path, which need not
+// exist yet.
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path:     path,
+		accounts: make(map[string]*Account),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []*Account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("reading accounts from %s: %w", path, err)
+	}
+	for _, account := range accounts {
+		s.accounts[account.ID] = account
+	}
+	return s, nil
+}
+
+func (s *FileStore) Get(id string) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, exists := s.accounts[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	copied := *account
+	return &copied, nil
+}
+
+func (s *FileStore) GetByUsername(username string) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if account := s.byUsername(username); account != nil {
+		copied := *account
+		return &copied, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *FileStore) Create(account *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.byUsername(account.Username) != nil {
+		return ErrUsernameTaken
+	}
+	copied := *account
+	s.accounts[account.ID] = &copied
+	if err := s.save(); err != nil {
+		delete(s.accounts, account.ID)
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Update(account *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, exists := s.accounts[account.ID]
+	if !exists {
+		return ErrNotFound
+	}
+	copied := *account
+	copied.Username = old.Username
+	s.accounts[account.ID] = &copied
+	if err := s.save(); err != nil {
+		s.accounts[account.ID] = old
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
+
+func (s *FileStore) byUsername(username string) *Account {
+	for _, account := range s.accounts {
+		if account.Username == username {
+			return account
+		}
+	}
+	return nil
+}
+
+// save writes every account to the file atomically by writing a temporary
+// file and renaming it into place. It must be called with s.mu held.
+func (s *FileStore) save() error {
+	accounts := make([]*Account, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, account)
+	}
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// Password hashes are nobody else's business
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,169 @@
+// Package auth issues and checks the session tokens that say who a request
+// comes from.
+//
+// A session belongs to one user in one room, or signs someone in to their
+// account when it has no room. Its token is the session as
+// base64url-encoded JSON, a dot, and an HMAC-SHA256 of that JSON, so the
+// server can trust it without keeping any state. Browsers get it as a
+// cookie; other clients send it as a bearer token.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SessionTTL is how long a session lasts after it is issued.
+const SessionTTL = 24 * time.Hour
+
+const (
+	cookiePrefix  = "synctunes_session_"
+	accountCookie = "synctunes_account"
+)
+
+var (
+	// ErrNoSession means the request carries no token for the room.
+	ErrNoSession = errors.New("no session")
+	// ErrInvalidSession means a token was forged, mangled or has expired.
+	ErrInvalidSession = errors.New("invalid session")
+)
+
+type Session struct {
+	RoomID  string `json:"room,omitempty"` // none for account sessions
+	UserID  string `json:"sub"`            // the account ID for account sessions
+	Expires int64  `json:"exp"`            // Unix seconds
+}
+
+// Sessions signs and verifies session tokens with a secret key.
+type Sessions struct {
+	secret []byte
+}
+
+func NewSessions(secret []byte) *Sessions {
+	return &Sessions{secret: secret}
+}
+
+// RandomSecret makes a new signing key. Sessions signed with it don't
+// outlive the process, and neither do rooms.
+func RandomSecret() ([]byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generating session secret: %w", err)
+	}
+	return secret, nil
+}
+
+// Issue starts a session for a user in a room, or for an account when
+// roomID is empty. The token is set as a cookie and also returned, for
+// clients that would rather send it as a bearer token.
+func (s *Sessions) Issue(w http.ResponseWriter, r *http.Request, roomID, userID string) (string, error) {
+	expires := time.Now().Add(SessionTTL)
+	token, err := s.sign(Session{
+		RoomID:  roomID,
+		UserID:  userID,
+		Expires: expires.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName(roomID),
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, nil
+}
+
+// End forgets the session a client has for a room, or for its account
+// when roomID is empty. Bearer tokens stay valid until they expire.
+func (s *Sessions) End(w http.ResponseWriter, roomID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName(roomID),
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// FromRequest returns the session a request carries for a room, or for an
+// account when roomID is empty, from its Authorization header or else its
+// cookie.
+func (s *Sessions) FromRequest(r *http.Request, roomID string) (Session, error) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		cookie, err := r.Cookie(cookieName(roomID))
+		if err != nil {
+			return Session{}, ErrNoSession
+		}
+		token = cookie.Value
+	}
+
+	session, err := s.Verify(token)
+	if err != nil {
+		return Session{}, err
+	}
+	if session.RoomID != roomID {
+		return Session{}, fmt.Errorf("%w: issued for another room", ErrInvalidSession)
+	}
+	return session, nil
+}
+
+// Verify checks a token's signature and expiry, and returns its session.
+func (s *Sessions) Verify(token string) (Session, error) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found {
+		return Session{}, ErrInvalidSession
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Session{}, ErrInvalidSession
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.mac(claims)) {
+		return Session{}, ErrInvalidSession
+	}
+
+	var session Session
+	if err := json.Unmarshal(claims, &session); err != nil {
+		return Session{}, ErrInvalidSession
+	}
+	if time.Now().Unix() >= session.Expires {
+		return Session{}, fmt.Errorf("%w: expired", ErrInvalidSession)
+	}
+	return session, nil
+}
+
+func cookieName(roomID string) string {
+	if roomID == "" {
+		return accountCookie
+	}
+	return cookiePrefix + roomID
+}
+
+func (s *Sessions) sign(session Session) (string, error) {
+	claims, err := json.Marshal(session)
+	if err != nil {
+		return "", fmt.Errorf("encoding session: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(claims) + "." +
+		base64.RawURLEncoding.EncodeToString(s.mac(claims)), nil
+}
+
+func (s *Sessions) mac(claims []byte) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write(claims)
+	return h.Sum(nil)
+}
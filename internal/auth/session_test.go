@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyRejectsBadTokens(t *testing.T) {
+	sessions := NewSessions([]byte("secret"))
+	expires := time.Now().Add(time.Hour).Unix()
+
+	valid, err := sessions.sign(Session{RoomID: "room", UserID: "user", Expires: expires})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, signature, _ := strings.Cut(valid, ".")
+
+	// The same claims, but naming someone else
+	forged := base64.RawURLEncoding.EncodeToString(
+		[]byte(`{"room":"room","sub":"host","exp":` + strconv.FormatInt(expires, 10) + `}`))
+
+	other, err := NewSessions([]byte("other secret")).sign(Session{RoomID: "room", UserID: "user", Expires: expires})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := sessions.sign(Session{RoomID: "room", UserID: "user", Expires: time.Now().Add(-time.Second).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, token string
+	}{
+		{"tampered claims", forged + "." + signature},
+		{"tampered MAC", payload + "." + flip(signature)},
+		{"truncated MAC", payload + "." + signature[:len(signature)-4]},
+		{"no MAC", payload + "."},
+		{"other secret", other},
+		{"expired", expired},
+		{"no separator", payload + signature},
+		{"malformed claims", "!!!." + signature},
+		{"malformed MAC", payload + ".!!!"},
+		{"claims not JSON", base64.RawURLEncoding.EncodeToString([]byte("user")) + "." +
+			base64.RawURLEncoding.EncodeToString(sessions.mac([]byte("user")))},
+		{"empty", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := sessions.Verify(tt.token); !errors.Is(err, ErrInvalidSession) {
+				t.Fatalf("Verify = %v, want %v", err, ErrInvalidSession)
+			}
+		})
+	}
+
+	if session, err := sessions.Verify(valid); err != nil || session.UserID != "user" {
+		t.Fatalf("Verify(valid) = %+v, %v", session, err)
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	sessions := NewSessions([]byte("secret"))
+
+	w := httptest.NewRecorder()
+	token, err := sessions.Issue(w, httptest.NewRequest("POST", "/api/rooms", nil), "room", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != token {
+		t.Fatalf("cookies = %v, want one holding the token", cookies)
+	}
+
+	bearer := httptest.NewRequest("GET", "/", nil)
+	bearer.Header.Set("Authorization", "Bearer "+token)
+	cookie := httptest.NewRequest("GET", "/", nil)
+	cookie.AddCookie(cookies[0])
+
+	var got []Session
+	for _, r := range []*http.Request{bearer, cookie} {
+		session, err := sessions.FromRequest(r, "room")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, session)
+	}
+	if got[0] != got[1] || got[0].UserID != "user" || got[0].RoomID != "room" {
+		t.Fatalf("bearer session %+v, cookie session %+v", got[0], got[1])
+	}
+
+	// A valid token for one room is no good in another
+	if _, err := sessions.FromRequest(bearer, "other"); !errors.Is(err, ErrInvalidSession) {
+		t.Fatalf("FromRequest for another room = %v, want %v", err, ErrInvalidSession)
+	}
+	// Nor is a room's cookie sent to another room's endpoints
+	if _, err := sessions.FromRequest(cookie, "other"); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("FromRequest with another room's cookie = %v, want %v", err, ErrNoSession)
+	}
+	// Nor as an account session
+	if _, err := sessions.FromRequest(bearer, ""); !errors.Is(err, ErrInvalidSession) {
+		t.Fatalf("FromRequest for an account = %v, want %v", err, ErrInvalidSession)
+	}
+	if _, err := sessions.FromRequest(httptest.NewRequest("GET", "/", nil), "room"); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("FromRequest without a token = %v, want %v", err, ErrNoSession)
+	}
+}
+
+// flip changes the first character of a base64url string to another one.
+func flip(s string) string {
+	if s[0] == 'A' {
+		return "B" + s[1:]
+	}
+	return "A" + s[1:]
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"synctunes/internal/account"
+)
+
+type RegisterRequest struct {
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	DisplayName string `json:"display_name"` // the username if not set
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type UpdateProfileRequest struct {
+	DisplayName string `json:"display_name"`
+}
+
+// AccountResponse is an account's profile, with the token of the session
+// that was started for it when signing in.
+type AccountResponse struct {
+	account.Profile
+	Token string `json:"token,omitempty"`
+}
+
+func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+
+	var req RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	acct, err := h.accounts.Register(req.Username, req.Password, req.DisplayName)
+	switch {
+	case errors.Is(err, account.ErrUsernameTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, account.ErrInvalidUsername), errors.Is(err, account.ErrInvalidPassword), errors.Is(err, account.ErrInvalidName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Failed to create account", http.StatusInternalServerError)
+		log.Printf("Account error: %v", err)
+		return
+	}
+
+	h.signIn(w, r, acct, http.StatusCreated)
+}
+
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	acct, err := h.accounts.Authenticate(req.Username, req.Password)
+	if errors.Is(err, account.ErrWrongPassword) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		log.Printf("Account error: %v", err)
+		return
+	}
+
+	h.signIn(w, r, acct, http.StatusOK)
+}
+
+// Logout signs the browser out of its account. It stays in the rooms it
+// joined, as the same user.
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	h.sessions.End(w, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMe returns the profile of the account the request is signed in to.
+func (h *Handler) GetMe(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+
+	acct := h.currentAccount(r)
+	if acct == nil {
+		http.Error(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AccountResponse{Profile: acct.Profile()})
+}
+
+// UpdateMe changes the signed in account's display name. Rooms it is
+// already in keep the old one.
+func (h *Handler) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	if !h.accountsEnabled(w) {
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	acct := h.currentAccount(r)
+	if acct == nil {
+		http.Error(w, "Not signed in", http.StatusUnauthorized)
+		return
+	}
+
+	acct, err := h.accounts.SetDisplayName(acct.ID, req.DisplayName)
+	if errors.Is(err, account.ErrInvalidName) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to update account", http.StatusInternalServerError)
+		log.Printf("Account error: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AccountResponse{Profile: acct.Profile()})
+}
+
+// signIn starts an account session and responds with the account.
+func (h *Handler) signIn(w http.ResponseWriter, r *http.Request, acct *account.Account, status int) {
+	token, err := h.sessions.Issue(w, r, "", acct.ID)
+	if err != nil {
+		http.Error(w, "Failed to start session", http.StatusInternalServerError)
+		log.Printf("Session error: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(AccountResponse{Profile: acct.Profile(), Token: token})
+}
+
+// currentAccount returns the account a request is signed in to, or nil
+// for anonymous requests and when accounts are disabled.
+func (h *Handler) currentAccount(r *http.Request) *account.Account {
+	if h.accounts == nil {
+		return nil
+	}
+	session, err := h.sessions.FromRequest(r, "")
+	if err != nil {
+		return nil
+	}
+	acct, err := h.accounts.Get(session.UserID)
+	if err != nil {
+		return nil
+	}
+	return acct
+}
+
+func (h *Handler) accountsEnabled(w http.ResponseWriter) bool {
+	if h.accounts == nil {
+		http.Error(w, "Accounts are disabled on this server", http.StatusNotFound)
+		return false
+	}
+	return true
+}
@@ -1,21 +1,26 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 
+	"synctunes/internal/account"
+	"synctunes/internal/auth"
 	"synctunes/internal/music"
+	"synctunes/internal/protocol"
 	"synctunes/internal/room"
 	"synctunes/internal/websocket"
 )
@@ -24,6 +29,8 @@ type Handler struct {
 	musicService *music.Service
 	roomManager  *room.Manager
 	wsHub        *websocket.Hub
+	sessions     *auth.Sessions
+	accounts     *account.Service // nil when accounts are disabled
 	templates    *template.Template
 }
 
@@ -32,24 +39,35 @@ type CreateRoomRequest struct {
 }
 
 type JoinRoomRequest struct {
-	UserName string `json:"user_name"`
+	UserName string `json:"user_name"` // the account's display name if not set
 }
 
 type PlayTrackRequest struct {
 	TrackID string `json:"track_id"`
-	UserID  string `json:"user_id"`
 }
 
 type SeekRequest struct {
-	Position int    `json:"position"`
-	UserID   string `json:"user_id"`
+	Position float64 `json:"position"` // in seconds, fractions allowed
 }
 
-type PlaybackControlRequest struct {
-	UserID string `json:"user_id"`
+type EnqueueRequest struct {
+	TrackID string `json:"track_id"`
+	AlbumID string `json:"album_id"` // queue a whole album instead, in disc and track order
+	Next    bool   `json:"next"`     // play after the current track instead of last
+}
+
+// PlaybackModeRequest changes only the fields that are set.
+type PlaybackModeRequest struct {
+	Repeat  *string `json:"repeat"`  // "off", "one" or "all"
+	Shuffle *bool   `json:"shuffle"`
+	Seed    *int64  `json:"seed"` // shuffle seed, random if not set
+}
+
+type MoveQueueItemRequest struct {
+	Index int `json:"index"`
 }
 
-func New(musicService *music.Service, roomManager *room.Manager, wsHub *websocket.Hub) *Handler {
+func New(musicService *music.Service, roomManager *room.Manager, wsHub *websocket.Hub, sessions *auth.Sessions, accounts *account.Service) *Handler {
 	// Define custom template functions
 	funcMap := template.FuncMap{
 		"json": func(v interface{}) template.JS {
@@ -65,12 +83,19 @@ func New(musicService *music.Service, roomManager *room.Manager, wsHub *websocke
 	templates := template.New("").Funcs(funcMap)
 	templates = template.Must(templates.ParseGlob("web/templates/*.html"))
 	
-	return &Handler{
+	h := &Handler{
 		musicService: musicService,
 		roomManager:  roomManager,
 		wsHub:        wsHub,
+		sessions:     sessions,
+		accounts:     accounts,
 		templates:    templates,
 	}
+	roomManager.OnAdvance(h.roomAdvanced)
+	roomManager.OnLeave(h.userLeft)
+	roomManager.OnHostChange(h.hostChanged)
+	roomManager.OnClose(h.roomClosed)
+	return h
 }
 
 func (h *Handler) HomePage(w http.ResponseWriter, r *http.Request) {
@@ -96,9 +121,12 @@ func (h *Handler) RoomPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// Check if this is the host by looking at the host_id query parameter
-	hostID := r.URL.Query().Get("host_id")
-	isHost := hostID != "" && room.IsHost(hostID)
+	// Only the host's own session gets the host page
+	var hostID string
+	if session, err := h.sessions.FromRequest(r, roomID); err == nil && room.IsHost(session.UserID) {
+		hostID = session.UserID
+	}
+	isHost := hostID != ""
 	
 	data := struct {
 		Title  string
@@ -163,6 +191,147 @@ func (h *Handler) GetMusicCatalog(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (h *Handler) SearchMusic(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	query := music.SearchQuery{
+		Text:   params.Get("q"),
+		Artist: params.Get("artist"),
+		Album:  params.Get("album"),
+		Genre:  params.Get("genre"),
+		Sort:   params.Get("sort"),
+		Cursor: params.Get("cursor"),
+	}
+
+	if v := params.Get("is_video"); v != "" {
+		isVideo, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "Invalid is_video", http.StatusBadRequest)
+			return
+		}
+		query.IsVideo = &isVideo
+	}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		query.Limit = limit
+	}
+
+	result, err := h.musicService.Search(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, "Error encoding results", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) GetArtists(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.musicService.GetArtists()); err != nil {
+		http.Error(w, "Error encoding artists", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) GetArtist(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	artistID := vars["id"]
+
+	artist, err := h.musicService.GetArtist(artistID)
+	if err != nil {
+		http.Error(w, "Artist not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(artist); err != nil {
+		http.Error(w, "Error encoding artist", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) GetAlbums(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.musicService.GetAlbums()); err != nil {
+		http.Error(w, "Error encoding albums", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) GetAlbum(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	albumID := vars["id"]
+
+	album, err := h.musicService.GetAlbum(albumID)
+	if err != nil {
+		http.Error(w, "Album not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(album); err != nil {
+		http.Error(w, "Error encoding album", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) GetArtwork(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	trackID := vars["id"]
+
+	size := 0
+	if v := r.URL.Query().Get("size"); v != "" {
+		var err error
+		size, err = strconv.Atoi(v)
+		if err != nil || size < 0 {
+			http.Error(w, "Invalid size", http.StatusBadRequest)
+			return
+		}
+	}
+
+	artwork, err := h.musicService.GetArtwork(trackID, size)
+	if errors.Is(err, music.ErrArtTooLarge) {
+		http.Error(w, "Artwork too large to scale", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Artwork not found", http.StatusNotFound)
+		return
+	}
+
+	// ServeContent answers If-None-Match and If-Modified-Since for us
+	w.Header().Set("Content-Type", artwork.ContentType)
+	w.Header().Set("ETag", artwork.ETag)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "", artwork.ModTime, bytes.NewReader(artwork.Data))
+}
+
+func (h *Handler) GetMusicStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.musicService.Status()); err != nil {
+		http.Error(w, "Error encoding status", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetHubStatus reports how the websocket hub is coping with slow clients.
+func (h *Handler) GetHubStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.wsHub.Stats()); err != nil {
+		http.Error(w, "Error encoding status", http.StatusInternalServerError)
+		return
+	}
+}
+
 func (h *Handler) StreamMusic(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	trackID := vars["id"]
@@ -218,26 +387,16 @@ func (h *Handler) StreamMusic(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	w.Header().Set("Content-Type", contentType)
-	w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
-	w.Header().Set("Accept-Ranges", "bytes")
-	
-	// Handle range requests for seeking
-	rangeHeader := r.Header.Get("Range")
-	if rangeHeader != "" {
-		h.handleRangeRequest(w, r, file, stat.Size())
-		return
-	}
-	
-	// Stream the entire file
-	io.Copy(w, file)
-}
 
-func (h *Handler) handleRangeRequest(w http.ResponseWriter, r *http.Request, file *os.File, fileSize int64) {
-	// Simple range request handling
-	// In a production app, you'd want more robust range parsing
-	w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", fileSize-1, fileSize))
-	w.WriteHeader(http.StatusPartialContent)
-	io.Copy(w, file)
+	// The track ID only covers the audio, so a retagged file needs a new
+	// validator too
+	etag := fmt.Sprintf(`"%s-%x-%x"`, track.ID, stat.ModTime().UnixNano(), stat.Size())
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// ServeContent handles single, multiple, suffix and open-ended ranges,
+	// 416 responses and the conditional request headers
+	http.ServeContent(w, r, filepath.Base(track.Path), stat.ModTime(), file)
 }
 
 func (h *Handler) CreateRoom(w http.ResponseWriter, r *http.Request) {
@@ -250,13 +409,25 @@ func (h *Handler) CreateRoom(w http.ResponseWriter, r *http.Request) {
 	roomID := uuid.New().String()
 	hostID := uuid.New().String() // In a real app, this would come from auth
 	
-	room := h.roomManager.CreateRoom(roomID, req.Name, hostID)
+	rm := h.roomManager.CreateRoom(roomID, req.Name, hostID)
+	if acct := h.currentAccount(r); acct != nil {
+		rm.LinkAccount(hostID, acct.ID, acct.DisplayName)
+	}
+	
+	token, err := h.sessions.Issue(w, r, roomID, hostID)
+	if err != nil {
+		h.roomManager.DeleteRoom(roomID, room.ClosedByHost)
+		http.Error(w, "Failed to start session", http.StatusInternalServerError)
+		log.Printf("Session error: %v", err)
+		return
+	}
 	
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"room_id": roomID,
 		"host_id": hostID,
-		"room_name": room.Name,
+		"room_name": rm.Name,
+		"token": token,
 	})
 }
 
@@ -275,6 +446,34 @@ func (h *Handler) GetRoom(w http.ResponseWriter, r *http.Request) {
 	w.Write(roomJSON)
 }
 
+// DeleteRoom closes a room for everyone in it. Only the host may close it.
+func (h *Handler) DeleteRoom(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID := vars["id"]
+
+	rm, exists := h.roomManager.GetRoom(roomID)
+	if !exists {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	userID, ok := h.sessionUser(w, r, roomID)
+	if !ok {
+		return
+	}
+	if !rm.IsHost(userID) {
+		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	// The room's close callback tells everyone in it
+	if !h.roomManager.DeleteRoom(roomID, room.ClosedByHost) {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	h.sessions.End(w, roomID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *Handler) JoinRoom(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	roomID := vars["id"]
@@ -285,22 +484,77 @@ func (h *Handler) JoinRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	userID := uuid.New().String() // In a real app, this would come from auth
+	rm, exists := h.roomManager.GetRoom(roomID)
+	if !exists {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return
+	}
+	
+	// Someone signed in to an account is the same member on every device
+	acct := h.currentAccount(r)
+	if acct != nil {
+		if member, ok := rm.AccountMember(acct.ID); ok {
+			h.resumeMember(w, r, roomID, member.ID)
+			return
+		}
+		if req.UserName == "" {
+			req.UserName = acct.DisplayName
+		}
+	}
+	
+	// Someone with a session is already in the room, or was until they
+	// were away too long, and comes back as the same member
+	userID := uuid.New().String()
+	if session, err := h.sessions.FromRequest(r, roomID); err == nil {
+		if _, ok := rm.GetUser(session.UserID); ok {
+			h.resumeMember(w, r, roomID, session.UserID)
+			return
+		}
+		userID = session.UserID
+	}
 	
 	if err := h.roomManager.JoinRoom(roomID, userID, req.UserName); err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	if acct != nil {
+		rm.LinkAccount(userID, acct.ID, req.UserName)
+	}
 	
-	// Broadcast room update
-	if room, exists := h.roomManager.GetRoom(roomID); exists {
-		roomJSON, _ := room.ToJSON()
-		h.wsHub.BroadcastToRoom(roomID, roomJSON)
+	token, err := h.sessions.Issue(w, r, roomID, userID)
+	if err != nil {
+		h.roomManager.LeaveRoom(roomID, userID)
+		http.Error(w, "Failed to start session", http.StatusInternalServerError)
+		log.Printf("Session error: %v", err)
+		return
 	}
 	
+	h.wsHub.BroadcastUserJoined(roomID, protocol.UserEvent{
+		UserID:   userID,
+		UserName: req.UserName,
+		Role:     rm.GetUserRole(userID),
+	})
+	
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"user_id": userID,
+		"token": token,
+	})
+}
+
+// resumeMember starts a new session for someone who is already in a room.
+func (h *Handler) resumeMember(w http.ResponseWriter, r *http.Request, roomID, userID string) {
+	token, err := h.sessions.Issue(w, r, roomID, userID)
+	if err != nil {
+		http.Error(w, "Failed to start session", http.StatusInternalServerError)
+		log.Printf("Session error: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"user_id": userID,
+		"token":   token,
 	})
 }
 
@@ -314,15 +568,9 @@ func (h *Handler) PlayTrack(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	room, exists := h.roomManager.GetRoom(roomID)
-	if !exists {
-		http.Error(w, "Room not found", http.StatusNotFound)
-		return
-	}
-	
 	// Check if user has permission to control playback
-	if !room.CanControlPlayback(req.UserID) {
-		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+	room, _, ok := h.authorize(w, r, roomID, room.CapPlay)
+	if !ok {
 		return
 	}
 	
@@ -335,8 +583,7 @@ func (h *Handler) PlayTrack(w http.ResponseWriter, r *http.Request) {
 	room.PlayTrack(track)
 	
 	// Broadcast room update
-	roomJSON, _ := room.ToJSON()
-	h.wsHub.BroadcastToRoom(roomID, roomJSON)
+	h.wsHub.BroadcastTrackChanged(room)
 	
 	w.WriteHeader(http.StatusOK)
 }
@@ -345,92 +592,287 @@ func (h *Handler) PauseRoom(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	roomID := vars["id"]
 	
-	var req PlaybackControlRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	// Check if user has permission to control playback
+	room, _, ok := h.authorize(w, r, roomID, room.CapPause)
+	if !ok {
 		return
 	}
 	
-	room, exists := h.roomManager.GetRoom(roomID)
-	if !exists {
-		http.Error(w, "Room not found", http.StatusNotFound)
-		return
-	}
+	room.Pause()
+	
+	// Broadcast room update
+	h.wsHub.BroadcastPlayback(room)
+	
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) ResumeRoom(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID := vars["id"]
 	
 	// Check if user has permission to control playback
-	if !room.CanControlPlayback(req.UserID) {
-		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+	room, _, ok := h.authorize(w, r, roomID, room.CapPause)
+	if !ok {
 		return
 	}
 	
-	room.Pause()
+	room.Resume()
 	
 	// Broadcast room update
-	roomJSON, _ := room.ToJSON()
-	h.wsHub.BroadcastToRoom(roomID, roomJSON)
+	h.wsHub.BroadcastPlayback(room)
 	
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *Handler) ResumeRoom(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) SeekTrack(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	roomID := vars["id"]
 	
-	var req PlaybackControlRequest
+	var req SeekRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 	
-	room, exists := h.roomManager.GetRoom(roomID)
-	if !exists {
-		http.Error(w, "Room not found", http.StatusNotFound)
-		return
-	}
-	
 	// Check if user has permission to control playback
-	if !room.CanControlPlayback(req.UserID) {
-		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+	room, _, ok := h.authorize(w, r, roomID, room.CapSeek)
+	if !ok {
 		return
 	}
 	
-	room.Resume()
+	room.Seek(time.Duration(req.Position * float64(time.Second)))
 	
 	// Broadcast room update
-	roomJSON, _ := room.ToJSON()
-	h.wsHub.BroadcastToRoom(roomID, roomJSON)
+	h.wsHub.BroadcastPlayback(room)
 	
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *Handler) SeekTrack(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) SetPlaybackMode(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	roomID := vars["id"]
-	
-	var req SeekRequest
+
+	var req PlaybackModeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
-	
+
+	var repeat room.RepeatMode
+	if req.Repeat != nil {
+		mode, err := room.ParseRepeatMode(*req.Repeat)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		repeat = mode
+	}
+
+	room, _, ok := h.authorize(w, r, roomID, room.CapSettings)
+	if !ok {
+		return
+	}
+
+	if req.Repeat != nil {
+		room.SetRepeat(repeat)
+	}
+	if req.Shuffle != nil {
+		seed := time.Now().UnixNano()
+		if req.Seed != nil {
+			seed = *req.Seed
+		}
+		room.SetShuffle(*req.Shuffle, seed)
+	}
+
+	// The snapshot carries the queue, which shuffling reorders
+	h.wsHub.BroadcastRoomState(room)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// sessionUser returns the user whose session a request carries for a room,
+// or writes an error response when there is none.
+func (h *Handler) sessionUser(w http.ResponseWriter, r *http.Request, roomID string) (string, bool) {
+	session, err := h.sessions.FromRequest(r, roomID)
+	if err != nil {
+		http.Error(w, "Not signed in to this room", http.StatusUnauthorized)
+		return "", false
+	}
+	return session.UserID, true
+}
+
+// authorize looks up a room and checks that the request's session belongs
+// to a member allowed to do something there, writing an error response
+// when it doesn't.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, roomID string, capability room.Capability) (*room.Room, string, bool) {
+	rm, exists := h.roomManager.GetRoom(roomID)
+	if !exists {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return nil, "", false
+	}
+
+	userID, ok := h.sessionUser(w, r, roomID)
+	if !ok {
+		return nil, "", false
+	}
+
+	if !rm.Can(userID, capability) {
+		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+		return nil, "", false
+	}
+	return rm, userID, true
+}
+
+// roomAdvanced tells a room's clients that it moved on to the next queued
+// track by itself.
+func (h *Handler) roomAdvanced(rm *room.Room) {
+	h.wsHub.BroadcastTrackChanged(rm)
+	h.wsHub.BroadcastQueue(rm.ID, rm.GetQueue())
+}
+
+// userLeft tells a room's clients that a member has been gone for longer
+// than the grace period and is no longer in the room.
+func (h *Handler) userLeft(rm *room.Room, user room.User) {
+	h.wsHub.BroadcastUserLeft(rm.ID, protocol.UserEvent{
+		UserID:   user.ID,
+		UserName: user.Name,
+		Role:     user.Role,
+	})
+}
+
+// hostChanged tells a room's clients who its new host is.
+func (h *Handler) hostChanged(rm *room.Room, change room.HostChange) {
+	h.wsHub.BroadcastHostChanged(rm.ID, change)
+}
+
+// roomClosed disconnects everyone in a room that was closed.
+func (h *Handler) roomClosed(rm *room.Room, reason room.CloseReason) {
+	h.wsHub.CloseRoom(rm.ID, reason)
+}
+
+func (h *Handler) GetQueue(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID := vars["id"]
+
 	room, exists := h.roomManager.GetRoom(roomID)
 	if !exists {
 		http.Error(w, "Room not found", http.StatusNotFound)
 		return
 	}
-	
-	// Check if user has permission to control playback
-	if !room.CanControlPlayback(req.UserID) {
-		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(room.GetQueue())
+}
+
+func (h *Handler) EnqueueTrack(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID := vars["id"]
+
+	var req EnqueueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
-	
-	room.Seek(req.Position)
-	
-	// Broadcast room update
-	roomJSON, _ := room.ToJSON()
-	h.wsHub.BroadcastToRoom(roomID, roomJSON)
-	
+
+	room, userID, ok := h.authorize(w, r, roomID, room.CapEnqueue)
+	if !ok {
+		return
+	}
+
+	if req.AlbumID != "" {
+		album, err := h.musicService.GetAlbum(req.AlbumID)
+		if err != nil {
+			http.Error(w, "Album not found", http.StatusNotFound)
+			return
+		}
+		items := room.EnqueueTracks(album.Tracks, userID, req.Next)
+
+		h.wsHub.BroadcastQueue(roomID, room.GetQueue())
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(items)
+		return
+	}
+
+	track, err := h.musicService.GetTrack(req.TrackID)
+	if err != nil {
+		http.Error(w, "Track not found", http.StatusNotFound)
+		return
+	}
+
+	enqueue := room.Enqueue
+	if req.Next {
+		enqueue = room.InsertNext
+	}
+	item := enqueue(track, userID)
+
+	h.wsHub.BroadcastQueue(roomID, room.GetQueue())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(item)
+}
+
+func (h *Handler) RemoveQueueItem(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID := vars["id"]
+	itemID := vars["itemId"]
+
+	room, _, ok := h.authorize(w, r, roomID, room.CapReorder)
+	if !ok {
+		return
+	}
+
+	if err := room.RemoveFromQueue(itemID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	h.wsHub.BroadcastQueue(roomID, room.GetQueue())
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) MoveQueueItem(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID := vars["id"]
+	itemID := vars["itemId"]
+
+	var req MoveQueueItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	room, _, ok := h.authorize(w, r, roomID, room.CapReorder)
+	if !ok {
+		return
+	}
+
+	if err := room.MoveInQueue(itemID, req.Index); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	h.wsHub.BroadcastQueue(roomID, room.GetQueue())
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) ClearQueue(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID := vars["id"]
+
+	room, _, ok := h.authorize(w, r, roomID, room.CapReorder)
+	if !ok {
+		return
+	}
+
+	room.ClearQueue()
+
+	h.wsHub.BroadcastQueue(roomID, room.GetQueue())
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -438,10 +880,17 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	roomID := vars["roomId"]
 	
-	// In a real app, you'd get userID from authentication
-	userID := r.URL.Query().Get("user_id")
-	if userID == "" {
-		userID = uuid.New().String()
+	if _, exists := h.roomManager.GetRoom(roomID); !exists {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	
+	// Anyone may watch a room; only a session says who they are
+	userID := uuid.New().String()
+	if session, err := h.sessions.FromRequest(r, roomID); err == nil {
+		userID = session.UserID
+	} else if !errors.Is(err, auth.ErrNoSession) {
+		log.Printf("Ignoring session for room %s: %v", roomID, err)
 	}
 	
 	h.wsHub.HandleWebSocket(w, r, roomID, userID)
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"synctunes/internal/protocol"
+	"synctunes/internal/room"
+)
+
+type SetRoleRequest struct {
+	Role string `json:"role"` // "co_host", "dj", "moderator" or "listener"
+}
+
+// SetUserRole grants a member a role, or revokes theirs by making them a
+// listener again. Only the host may hand out roles.
+func (h *Handler) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID := vars["id"]
+	userID := vars["userId"]
+
+	var req SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	role, err := room.ParseGrantableRole(req.Role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rm, exists := h.roomManager.GetRoom(roomID)
+	if !exists {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	hostID, ok := h.sessionUser(w, r, roomID)
+	if !ok {
+		return
+	}
+	if !rm.IsHost(hostID) {
+		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+		return
+	}
+
+	user, err := rm.SetRole(userID, role)
+	switch {
+	case errors.Is(err, room.ErrNotMember):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	h.wsHub.BroadcastRoleChanged(roomID, user)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+type TransferHostRequest struct {
+	UserID string `json:"user_id"` // the member to become host
+}
+
+// TransferHost makes another member the host. The previous host stays on as
+// a co-host.
+func (h *Handler) TransferHost(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID := vars["id"]
+
+	var req TransferHostRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	rm, exists := h.roomManager.GetRoom(roomID)
+	if !exists {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+	hostID, ok := h.sessionUser(w, r, roomID)
+	if !ok {
+		return
+	}
+
+	// The room's host change callback announces it
+	change, err := rm.TransferHost(hostID, req.UserID)
+	switch {
+	case errors.Is(err, room.ErrNotHost):
+		http.Error(w, "Insufficient permissions", http.StatusForbidden)
+		return
+	case errors.Is(err, room.ErrNotMember):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(change.Next)
+}
+
+// KickUser removes a member from a room and disconnects them. They can
+// join again, as a new listener.
+func (h *Handler) KickUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	roomID := vars["id"]
+	userID := vars["userId"]
+
+	rm, byUserID, ok := h.authorize(w, r, roomID, room.CapKick)
+	if !ok {
+		return
+	}
+
+	user, err := rm.Kick(byUserID, userID)
+	switch {
+	case errors.Is(err, room.ErrNotMember):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, room.ErrOutranked):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	h.wsHub.BroadcastKicked(roomID, protocol.UserEvent{
+		UserID:   user.ID,
+		UserName: user.Name,
+		Role:     user.Role,
+	})
+
+	w.WriteHeader(http.StatusOK)
+}
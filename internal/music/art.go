@@ -0,0 +1,362 @@
+package music
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ID3/FLAC picture type for the front cover
+const pictureFrontCover = 3
+
+const (
+	MaxArtSize = 1024
+	MinArtSize = 16
+)
+
+// maxArtPixels bounds the images decoded for scaling. Every pixel takes
+// four bytes once decoded, however small the file claiming it is.
+const maxArtPixels = 40_000_000
+
+// ErrArtTooLarge means cover art has too many pixels to be scaled.
+var ErrArtTooLarge = errors.New("artwork too large")
+
+// Picture is an image embedded in a file's tags.
+type Picture struct {
+	MIMEType string
+	Data     []byte
+	kind     byte // ID3/FLAC picture type
+}
+
+// Artwork is cover art ready to be served.
+type Artwork struct {
+	Data        []byte
+	ContentType string
+	ETag        string
+	ModTime     time.Time
+}
+
+// sidecarArtNames are the image files checked, in order, when a track has
+// no embedded picture.
+var sidecarArtNames = []string{
+	"cover.jpg", "cover.jpeg", "cover.png",
+	"folder.jpg", "folder.jpeg", "folder.png",
+	"front.jpg", "front.jpeg", "front.png",
+	"album.jpg", "album.jpeg", "album.png",
+}
+
+func newPicture(data []byte, kind byte) *Picture {
+	mimeType := http.DetectContentType(data)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return nil
+	}
+	return &Picture{MIMEType: mimeType, Data: data, kind: kind}
+}
+
+// addPicture keeps the first picture found, unless a later one is the
+// front cover.
+func (t *Tags) addPicture(p *Picture) {
+	if p == nil {
+		return
+	}
+	if t.Picture == nil || (t.Picture.kind != pictureFrontCover && p.kind == pictureFrontCover) {
+		t.Picture = p
+	}
+}
+
+// parseFLACPicture decodes a FLAC PICTURE metadata block.
+func parseFLACPicture(data []byte) *Picture {
+	pos := 0
+	readUint32 := func() (int, bool) {
+		if pos+4 > len(data) {
+			return 0, false
+		}
+		v := int(binary.BigEndian.Uint32(data[pos:]))
+		pos += 4
+		return v, true
+	}
+
+	kind, ok := readUint32()
+	if !ok {
+		return nil
+	}
+	// MIME type and description
+	for i := 0; i < 2; i++ {
+		n, ok := readUint32()
+		if !ok || n < 0 || pos+n > len(data) {
+			return nil
+		}
+		pos += n
+	}
+	// Width, height, colour depth and palette size
+	pos += 16
+	n, ok := readUint32()
+	if !ok || n < 0 || pos+n > len(data) {
+		return nil
+	}
+	return newPicture(data[pos:pos+n], byte(kind))
+}
+
+// isSidecarArt reports whether path is named like a sidecar cover image.
+func isSidecarArt(path string) bool {
+	name := strings.ToLower(filepath.Base(path))
+	for _, candidate := range sidecarArtNames {
+		if name == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// findSidecarArt returns the path of the preferred cover image in dir.
+func findSidecarArt(dir string) (string, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+
+	found := make(map[string]string)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			found[strings.ToLower(entry.Name())] = entry.Name()
+		}
+	}
+	for _, candidate := range sidecarArtNames {
+		if name, ok := found[candidate]; ok {
+			return filepath.Join(dir, name), true
+		}
+	}
+	return "", false
+}
+
+// setArt points the track at its artwork: the track file itself when the
+// tags carry a picture, otherwise a sidecar image found by lookup.
+func (t *Track) setArt(embedded bool, lookup func(dir string) (string, bool)) {
+	t.ArtPath = ""
+	if embedded {
+		t.ArtPath = t.Path
+	} else if sidecar, ok := lookup(filepath.Dir(t.Path)); ok {
+		t.ArtPath = sidecar
+	}
+
+	t.ArtURL = ""
+	if t.ArtPath != "" {
+		t.ArtURL = artURL(t.ID)
+	}
+}
+
+func artURL(trackID string) string {
+	return "/api/music/art/" + trackID
+}
+
+// hasEmbeddedArt reports whether the track's artwork comes from its own
+// tags rather than a sidecar file.
+func (t *Track) hasEmbeddedArt() bool {
+	return t.ArtPath != "" && t.ArtPath == t.Path
+}
+
+// GetArtwork returns the cover art for a track, scaled to fit within
+// size×size pixels. A size of 0 returns the original image. Scaled images
+// are cached on disk next to the catalog index.
+func (s *Service) GetArtwork(trackID string, size int) (*Artwork, error) {
+	track, err := s.GetTrack(trackID)
+	if err != nil {
+		return nil, err
+	}
+	if track.ArtPath == "" {
+		return nil, fmt.Errorf("no artwork")
+	}
+
+	info, err := os.Stat(track.ArtPath)
+	if err != nil {
+		return nil, fmt.Errorf("no artwork")
+	}
+
+	if size != 0 {
+		size = min(max(size, MinArtSize), MaxArtSize)
+	}
+
+	// The cache key covers everything the output depends on, and doubles
+	// as the ETag
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%d\x00%d", track.ArtPath, info.ModTime().UnixNano(), info.Size(), size)
+	key := hex.EncodeToString(h.Sum(nil))[:20]
+
+	artwork := &Artwork{
+		ETag:    `"` + key + `"`,
+		ModTime: info.ModTime(),
+	}
+
+	cachePath := ""
+	if s.artCacheDir != "" {
+		cachePath = filepath.Join(s.artCacheDir, key)
+		if data, err := os.ReadFile(cachePath); err == nil {
+			artwork.Data = data
+			artwork.ContentType = http.DetectContentType(data)
+			return artwork, nil
+		}
+	}
+
+	picture, err := loadPicture(track)
+	if err != nil {
+		return nil, err
+	}
+
+	data := picture.Data
+	if size != 0 {
+		data, err = scaleImage(picture.Data, size)
+		if err != nil {
+			return nil, err
+		}
+	}
+	artwork.Data = data
+	artwork.ContentType = http.DetectContentType(data)
+
+	if cachePath != "" {
+		if err := writeFileAtomic(cachePath, data); err != nil {
+			// Still serve the image, just without caching it
+			log.Printf("Error caching artwork: %v", err)
+		}
+	}
+	return artwork, nil
+}
+
+func loadPicture(track *Track) (*Picture, error) {
+	if track.hasEmbeddedArt() {
+		tags, err := ReadTags(track.Path)
+		if err != nil || tags.Picture == nil {
+			return nil, fmt.Errorf("no artwork")
+		}
+		return tags.Picture, nil
+	}
+
+	data, err := os.ReadFile(track.ArtPath)
+	if err != nil {
+		return nil, err
+	}
+	picture := newPicture(data, pictureFrontCover)
+	if picture == nil {
+		return nil, fmt.Errorf("unsupported image")
+	}
+	return picture, nil
+}
+
+// scaleImage shrinks an encoded image to fit within size×size, keeping its
+// aspect ratio. PNGs stay PNG so transparency survives; everything else is
+// re-encoded as JPEG.
+func scaleImage(data []byte, size int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if int64(config.Width)*int64(config.Height) > maxArtPixels {
+		return nil, ErrArtTooLarge
+	}
+
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > size || h > size {
+		if w >= h {
+			h = max(1, h*size/w)
+			w = size
+		} else {
+			w = max(1, w*size/h)
+			h = size
+		}
+	}
+
+	dst := boxResize(src, w, h)
+
+	var buf bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&buf, dst)
+	} else {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// boxResize scales src to w×h by averaging the source pixels covered by
+// each destination pixel, which gives clean results when shrinking.
+func boxResize(src image.Image, w, h int) *image.RGBA {
+	bounds := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	srcW, srcH := rgba.Rect.Dx(), rgba.Rect.Dy()
+	if srcW == w && srcH == h {
+		return rgba
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := y * srcH / h
+		y1 := max((y+1)*srcH/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := x * srcW / w
+			x1 := max((x+1)*srcW/w, x0+1)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0] = uint8(r / n)
+			d[1] = uint8(g / n)
+			d[2] = uint8(b / n)
+			d[3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,82 @@
+package music
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestScaleImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := scaleImage(buf.Bytes(), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scaled, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" || scaled.Bounds().Dx() != 64 || scaled.Bounds().Dy() != 32 {
+		t.Fatalf("scaled to a %s of %v, want a png of 64×32", format, scaled.Bounds())
+	}
+	if c := color.RGBAModel.Convert(scaled.At(10, 10)).(color.RGBA); c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Fatalf("pixel = %v, want white", c)
+	}
+}
+
+func TestScaleImageTooLarge(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"png", pngHeader(50000, 50000)},
+		{"jpeg", jpegHeader(50000, 50000)},
+		{"just over the budget", pngHeader(maxArtPixels/1000+1, 1000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if n := allocated(func() { _, err = scaleImage(tt.data, 64) }); n > maxParseAlloc {
+				t.Errorf("allocated %d bytes", n)
+			}
+			if !errors.Is(err, ErrArtTooLarge) {
+				t.Fatalf("scaleImage = %v, want %v", err, ErrArtTooLarge)
+			}
+		})
+	}
+}
+
+// pngHeader is the start of an 8-bit RGBA PNG of the given size.
+func pngHeader(width, height uint32) []byte {
+	ihdr := []byte("IHDR")
+	ihdr = binary.BigEndian.AppendUint32(ihdr, width)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, height)
+	ihdr = append(ihdr, 8, 6, 0, 0, 0)
+
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(ihdr)-4))
+	data = append(data, ihdr...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(ihdr))
+}
+
+// jpegHeader is the start of a greyscale baseline JPEG of the given size.
+func jpegHeader(width, height uint16) []byte {
+	data := []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")
+	data = append(data, 0xFF, 0xC0, 0x00, 0x0B, 0x08)
+	data = binary.BigEndian.AppendUint16(data, height)
+	data = binary.BigEndian.AppendUint16(data, width)
+	return append(data, 0x01, 0x01, 0x11, 0x00)
+}
@@ -0,0 +1,469 @@
+package music
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ReadDuration measures the playing time of the file at path. MP3, FLAC,
+// WAV, OGG (Vorbis, Opus, FLAC) and MP4/M4A/MOV are supported.
+func ReadDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		return mp3Duration(f)
+	case ".flac":
+		return flacDuration(f)
+	case ".wav":
+		return wavDuration(f)
+	case ".ogg":
+		return oggDuration(f)
+	case ".m4a", ".mp4", ".mov":
+		return mp4Duration(f)
+	}
+	return 0, fmt.Errorf("unsupported duration format")
+}
+
+func samplesToDuration(samples int64, sampleRate int) time.Duration {
+	if sampleRate <= 0 {
+		return 0
+	}
+	return time.Duration(samples * int64(time.Second) / int64(sampleRate))
+}
+
+// MP3
+
+type mpegVersion int
+
+const (
+	mpeg25 mpegVersion = iota
+	mpegReserved
+	mpeg2
+	mpeg1
+)
+
+var mpegBitrates = map[mpegVersion][4][16]int{
+	mpeg1: {
+		{},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},     // Layer III
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},    // Layer II
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448}, // Layer I
+	},
+	mpeg2: {
+		{},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	},
+}
+
+var mpegSampleRates = map[mpegVersion][3]int{
+	mpeg1:  {44100, 48000, 32000},
+	mpeg2:  {22050, 24000, 16000},
+	mpeg25: {11025, 12000, 8000},
+}
+
+type mpegFrame struct {
+	version    mpegVersion
+	layer      int // 1, 2 or 3
+	bitrate    int // bits per second
+	sampleRate int
+	padding    int
+	mono       bool
+}
+
+func parseMPEGHeader(h []byte) (*mpegFrame, bool) {
+	if len(h) < 4 || h[0] != 0xFF || h[1]&0xE0 != 0xE0 {
+		return nil, false
+	}
+
+	version := mpegVersion((h[1] >> 3) & 0x03)
+	layerBits := int((h[1] >> 1) & 0x03)
+	bitrateIndex := int(h[2] >> 4)
+	rateIndex := int((h[2] >> 2) & 0x03)
+	if version == mpegReserved || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return nil, false
+	}
+
+	tableVersion := version
+	if version == mpeg25 {
+		tableVersion = mpeg2
+	}
+
+	return &mpegFrame{
+		version:    version,
+		layer:      4 - layerBits,
+		bitrate:    mpegBitrates[tableVersion][layerBits][bitrateIndex] * 1000,
+		sampleRate: mpegSampleRates[version][rateIndex],
+		padding:    int((h[2] >> 1) & 0x01),
+		mono:       h[3]>>6 == 0x03,
+	}, true
+}
+
+func (f *mpegFrame) samples() int {
+	switch {
+	case f.layer == 1:
+		return 384
+	case f.layer == 3 && f.version != mpeg1:
+		return 576
+	}
+	return 1152
+}
+
+func (f *mpegFrame) length() int {
+	if f.layer == 1 {
+		return (12*f.bitrate/f.sampleRate + f.padding) * 4
+	}
+	return f.samples()/8*f.bitrate/f.sampleRate + f.padding
+}
+
+// sideInfoSize is the size of the Layer III side information that sits
+// between the frame header and a Xing/Info header.
+func (f *mpegFrame) sideInfoSize() int {
+	switch {
+	case f.version == mpeg1 && f.mono:
+		return 17
+	case f.version == mpeg1:
+		return 32
+	case f.mono:
+		return 9
+	}
+	return 17
+}
+
+// mp3Duration uses the Xing/Info or VBRI header of the first frame when
+// present and otherwise walks every frame in the stream.
+func mp3Duration(f *os.File) (time.Duration, error) {
+	_, start, _ := readID3v2(f)
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	r := bufio.NewReaderSize(f, 64*1024)
+
+	// Find the first frame sync within a reasonable distance
+	var first *mpegFrame
+	for skipped := 0; skipped < 64*1024; skipped++ {
+		h, err := r.Peek(4)
+		if err != nil {
+			return 0, fmt.Errorf("no MPEG frames found")
+		}
+		if frame, ok := parseMPEGHeader(h); ok {
+			first = frame
+			break
+		}
+		r.Discard(1)
+	}
+	if first == nil {
+		return 0, fmt.Errorf("no MPEG frames found")
+	}
+
+	if frames, ok := mp3VBRFrameCount(r, first); ok {
+		return samplesToDuration(frames*int64(first.samples()), first.sampleRate), nil
+	}
+
+	var samples int64
+	h := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, h); err != nil {
+			break
+		}
+		frame, ok := parseMPEGHeader(h)
+		if !ok {
+			break
+		}
+		samples += int64(frame.samples())
+		if _, err := r.Discard(frame.length() - 4); err != nil {
+			break
+		}
+	}
+
+	if samples == 0 {
+		return 0, fmt.Errorf("no MPEG frames found")
+	}
+	return samplesToDuration(samples, first.sampleRate), nil
+}
+
+func mp3VBRFrameCount(r *bufio.Reader, frame *mpegFrame) (int64, bool) {
+	n := frame.length()
+	if n < 4 {
+		return 0, false
+	}
+	b, err := r.Peek(n)
+	if err != nil {
+		b, _ = r.Peek(r.Buffered())
+	}
+
+	if frame.layer == 3 {
+		off := 4 + frame.sideInfoSize()
+		if off+12 <= len(b) {
+			tag := string(b[off : off+4])
+			if tag == "Xing" || tag == "Info" {
+				flags := binary.BigEndian.Uint32(b[off+4:])
+				if flags&0x01 != 0 {
+					return int64(binary.BigEndian.Uint32(b[off+8:])), true
+				}
+			}
+		}
+	}
+
+	// VBRI sits at a fixed offset of 32 bytes after the header
+	const vbriOffset = 36
+	if vbriOffset+18 <= len(b) && string(b[vbriOffset:vbriOffset+4]) == "VBRI" {
+		return int64(binary.BigEndian.Uint32(b[vbriOffset+14:])), true
+	}
+
+	return 0, false
+}
+
+// FLAC
+
+type flacInfo struct {
+	sampleRate   int
+	totalSamples int64
+}
+
+func parseFLACStreamInfo(data []byte) (*flacInfo, bool) {
+	if len(data) < 18 {
+		return nil, false
+	}
+	v := binary.BigEndian.Uint64(data[10:18])
+	return &flacInfo{
+		sampleRate:   int(v >> 44),
+		totalSamples: int64(v & 0xFFFFFFFFF),
+	}, true
+}
+
+func flacDuration(f *os.File) (time.Duration, error) {
+	var info *flacInfo
+	err := walkFLACMetadata(f, func(blockType byte, data []byte) bool {
+		if blockType == flacStreamInfo {
+			info, _ = parseFLACStreamInfo(data)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if info == nil || info.totalSamples == 0 {
+		return 0, fmt.Errorf("unknown FLAC length")
+	}
+	return samplesToDuration(info.totalSamples, info.sampleRate), nil
+}
+
+// WAV
+
+func wavDuration(f *os.File) (time.Duration, error) {
+	_, size, byteRate, err := wavDataChunk(f)
+	if err != nil {
+		return 0, err
+	}
+	if byteRate == 0 {
+		return 0, fmt.Errorf("missing fmt chunk")
+	}
+	return time.Duration(size * int64(time.Second) / int64(byteRate)), nil
+}
+
+// wavFmtMaxSize is the size of the largest fmt chunk, WAVE_FORMAT_EXTENSIBLE.
+const wavFmtMaxSize = 40
+
+// wavDataChunk locates the sample data of a WAV file, returning its offset
+// and size along with the byte rate from the fmt chunk.
+func wavDataChunk(f *os.File) (offset, size int64, byteRate uint32, err error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, 0, err
+	}
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return 0, 0, 0, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return 0, 0, 0, fmt.Errorf("not a WAV file")
+	}
+
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(f, chunk); err != nil {
+			return 0, 0, 0, fmt.Errorf("data chunk not found")
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		skip := size + size%2 // chunks are padded to an even size
+
+		switch id {
+		case "fmt ":
+			// Only the start of the chunk matters, whatever size it claims
+			fmtData := make([]byte, min(size, wavFmtMaxSize))
+			if _, err := io.ReadFull(f, fmtData); err != nil {
+				return 0, 0, 0, err
+			}
+			if len(fmtData) >= 12 {
+				byteRate = binary.LittleEndian.Uint32(fmtData[8:12])
+			}
+			skip -= int64(len(fmtData))
+		case "data":
+			pos, _ := f.Seek(0, io.SeekCurrent)
+			end, _ := f.Seek(0, io.SeekEnd)
+			// Streams written without a known length use 0 or 0xFFFFFFFF
+			if size == 0 || size == 0xFFFFFFFF || pos+size > end {
+				size = end - pos
+			}
+			return pos, size, byteRate, nil
+		}
+
+		if _, err := f.Seek(skip, io.SeekCurrent); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+}
+
+// OGG
+
+// oggTailSize is how much of the end of an Ogg file is searched for the
+// last page of the stream
+const oggTailSize = 64 * 1024
+
+func oggDuration(f *os.File) (time.Duration, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	first, err := readOggPageHeader(f)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	ident, err := newOggPacketReader(f).next()
+	if err != nil {
+		return 0, err
+	}
+
+	var sampleRate int
+	var preSkip int64
+	switch {
+	case bytes.HasPrefix(ident, []byte("\x01vorbis")) && len(ident) >= 16:
+		sampleRate = int(binary.LittleEndian.Uint32(ident[12:16]))
+	case bytes.HasPrefix(ident, []byte("OpusHead")) && len(ident) >= 12:
+		// Opus granule positions always count 48 kHz samples
+		sampleRate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(ident[10:12]))
+	case bytes.HasPrefix(ident, []byte("\x7fFLAC")) && len(ident) >= 13+4+18:
+		info, ok := parseFLACStreamInfo(ident[13+4:])
+		if !ok {
+			return 0, fmt.Errorf("invalid Ogg FLAC header")
+		}
+		sampleRate = info.sampleRate
+	default:
+		return 0, fmt.Errorf("unsupported Ogg codec")
+	}
+
+	granule, err := lastOggGranule(f, first.serial)
+	if err != nil {
+		return 0, err
+	}
+	return samplesToDuration(granule-preSkip, sampleRate), nil
+}
+
+// lastOggGranule returns the granule position of the last page belonging
+// to the logical stream with the given serial number.
+func lastOggGranule(f *os.File, serial uint32) (int64, error) {
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	start := end - oggTailSize
+	if start < 0 {
+		start = 0
+	}
+	tail := make([]byte, end-start)
+	if _, err := f.ReadAt(tail, start); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	for i := len(tail) - oggPageHeaderSize; i >= 0; i-- {
+		if string(tail[i:i+4]) != "OggS" {
+			continue
+		}
+		header, err := readOggPageHeader(bytes.NewReader(tail[i:]))
+		if err != nil || header.serial != serial || header.granule < 0 {
+			continue
+		}
+		return header.granule, nil
+	}
+	return 0, fmt.Errorf("no granule position found")
+}
+
+// MP4
+
+// mp4TimedDuration decodes the timescale and duration fields shared by
+// the mvhd and mdhd boxes.
+func mp4TimedDuration(data []byte) (time.Duration, bool) {
+	if len(data) < 4 {
+		return 0, false
+	}
+
+	var timescale uint32
+	var duration uint64
+	if data[0] == 1 {
+		if len(data) < 32 {
+			return 0, false
+		}
+		timescale = binary.BigEndian.Uint32(data[20:24])
+		duration = binary.BigEndian.Uint64(data[24:32])
+	} else {
+		if len(data) < 20 {
+			return 0, false
+		}
+		timescale = binary.BigEndian.Uint32(data[12:16])
+		duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	}
+
+	if timescale == 0 || duration == 0 || duration == 0xFFFFFFFF {
+		return 0, false
+	}
+	return time.Duration(duration * uint64(time.Second) / uint64(timescale)), true
+}
+
+func mp4Duration(f *os.File) (time.Duration, error) {
+	moov, err := readMoov(f)
+	if err != nil {
+		return 0, err
+	}
+
+	if mvhd, ok := mp4Find(moov, "mvhd"); ok {
+		if d, ok := mp4TimedDuration(mvhd); ok {
+			return d, nil
+		}
+	}
+
+	// Fall back to the longest track
+	var longest time.Duration
+	for _, atom := range mp4Children(moov) {
+		if atom.kind != "trak" {
+			continue
+		}
+		if mdhd, ok := mp4Find(atom.data, "mdia", "mdhd"); ok {
+			if d, ok := mp4TimedDuration(mdhd); ok && d > longest {
+				longest = d
+			}
+		}
+	}
+	if longest == 0 {
+		return 0, fmt.Errorf("unknown MP4 duration")
+	}
+	return longest, nil
+}
@@ -0,0 +1,293 @@
+package music
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestReadDuration(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data []byte
+		want time.Duration
+	}{
+		{
+			name: "MP3 frames",
+			file: "a.mp3",
+			data: mp3Frames(mp3Stereo128k, 100),
+			want: samplesToDuration(100*1152, 44100),
+		},
+		{
+			name: "MP3 frames after an ID3v2 tag and junk",
+			file: "a.mp3",
+			data: bytes.Join([][]byte{
+				id3v2Tag(3, id3v2Frame(3, "TIT2", id3v2Text("Title"))),
+				[]byte("junk"),
+				mp3Frames(mp3Stereo128k, 100),
+			}, nil),
+			want: samplesToDuration(100*1152, 44100),
+		},
+		{
+			name: "MP3 Xing header",
+			file: "a.mp3",
+			data: append(mp3VBRFrame(mp3Stereo128k, 4+32, "Xing", 1000), mp3Frames(mp3Stereo128k, 10)...),
+			want: samplesToDuration(1000*1152, 44100),
+		},
+		{
+			name: "MP3 Info header",
+			file: "a.mp3",
+			data: append(mp3VBRFrame(mp3Stereo128k, 4+32, "Info", 1000), mp3Frames(mp3Stereo128k, 10)...),
+			want: samplesToDuration(1000*1152, 44100),
+		},
+		{
+			name: "MPEG-2 mono Xing header",
+			file: "a.mp3",
+			data: append(mp3VBRFrame(mp3Mono64k, 4+9, "Xing", 500), mp3Frames(mp3Mono64k, 10)...),
+			want: samplesToDuration(500*576, 22050),
+		},
+		{
+			name: "MP3 VBRI header",
+			file: "a.mp3",
+			data: append(mp3VBRFrame(mp3Stereo128k, 36, "VBRI", 2000), mp3Frames(mp3Stereo128k, 10)...),
+			want: samplesToDuration(2000*1152, 44100),
+		},
+		{
+			name: "FLAC",
+			file: "a.flac",
+			data: flacStream(flacBlock(flacStreamInfo, flacStreamInfoData(44100, 44100*30))),
+			want: 30 * time.Second,
+		},
+		{
+			name: "FLAC after an ID3v2 tag",
+			file: "a.flac",
+			data: append(id3v2Tag(3, id3v2Frame(3, "TIT2", id3v2Text("Title"))),
+				flacStream(flacBlock(flacStreamInfo, flacStreamInfoData(48000, 48000*90)), flacBlock(flacPadding, make([]byte, 100)))...),
+			want: 90 * time.Second,
+		},
+		{
+			name: "WAV",
+			file: "a.wav",
+			data: wavFile(wavFmt(16), 88200),
+			want: 500 * time.Millisecond,
+		},
+		{
+			name: "WAV with a fmt chunk larger than its fields, and padding",
+			file: "a.wav",
+			data: bytes.Join([][]byte{
+				wavFile(wavFmt(wavFmtMaxSize+11), 0)[:12+8+wavFmtMaxSize+11+1],
+				[]byte("LIST\x03\x00\x00\x00abc\x00"),
+				[]byte("data"), binary.LittleEndian.AppendUint32(nil, 176400), make([]byte, 176400),
+			}, nil),
+			want: time.Second,
+		},
+		{
+			name: "WAV with an unknown data length",
+			file: "a.wav",
+			data: append(wavFile(wavFmt(16), 0)[:12+8+16], append([]byte("data\xff\xff\xff\xff"), make([]byte, 44100)...)...),
+			want: 250 * time.Millisecond,
+		},
+		{
+			name: "Ogg Vorbis",
+			file: "a.ogg",
+			data: bytes.Join([][]byte{
+				oggPage(0x02, 0, vorbisIdent(44100)),
+				oggPage(0, 0, append([]byte("\x03vorbis"), vorbisComment("test")...)),
+				oggPage(0, 44100*4, make([]byte, 1000)),
+				oggPage(0x04, 44100*10, make([]byte, 1000)),
+			}, nil),
+			want: 10 * time.Second,
+		},
+		{
+			name: "Ogg Vorbis followed by another stream",
+			file: "a.ogg",
+			data: bytes.Join([][]byte{
+				oggPage(0x02, 0, vorbisIdent(44100)),
+				oggPage(0x04, 44100*10, make([]byte, 1000)),
+				oggSerial(oggPage(0x04, 44100*99, make([]byte, 1000)), 2),
+			}, nil),
+			want: 10 * time.Second,
+		},
+		{
+			name: "Ogg Opus",
+			file: "a.ogg",
+			data: bytes.Join([][]byte{
+				oggPage(0x02, 0, opusIdent(312)),
+				oggPage(0x04, 48000*5+312, make([]byte, 1000)),
+			}, nil),
+			want: 5 * time.Second,
+		},
+		{
+			name: "MP4 mvhd version 0",
+			file: "a.m4a",
+			data: mp4File(mp4Box("mvhd", mp4TimedData(0, 1000, 185500))),
+			want: 185500 * time.Millisecond,
+		},
+		{
+			name: "MP4 mvhd version 1",
+			file: "a.m4a",
+			data: mp4File(mp4Box("mvhd", mp4TimedData(1, 44100, 44100*108000))),
+			want: 30 * time.Hour,
+		},
+		{
+			name: "MP4 longest mdhd",
+			file: "a.mp4",
+			data: mp4File(
+				mp4Box("mvhd", mp4TimedData(0, 1000, 0)),
+				mp4Box("trak", mp4Box("mdia", mp4Box("mdhd", mp4TimedData(0, 600, 600*20)))),
+				mp4Box("trak", mp4Box("mdia", mp4Box("mdhd", mp4TimedData(1, 44100, 44100*21)))),
+			),
+			want: 21 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := ReadDuration(writeFile(t, tt.file, tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d != tt.want {
+				t.Fatalf("ReadDuration = %v, want %v", d, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadDurationHostile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data []byte
+	}{
+		{"WAV fmt chunk larger than the file", "a.wav", wavFile(wavFmt(0xFFFFFFF0), 88200)},
+		{"WAV fmt chunk shorter than its fields", "a.wav", wavFile(wavFmt(4), 88200)},
+		{"MP3 without frames", "a.mp3", bytes.Repeat([]byte{0xFF, 0x00}, 1000)},
+		{"MP3 with only an ID3v2 tag", "a.mp3", []byte("ID3\x03\x00\x00\x7f\x7f\x7f\x7f")},
+		{"FLAC STREAMINFO cut short", "a.flac", flacStream(flacBlock(flacStreamInfo, make([]byte, 10)))},
+		{"FLAC block larger than the file", "a.flac", []byte("fLaC\x80\xff\xff\xff")},
+		{"Ogg without pages", "a.ogg", []byte("OggS")},
+		{"MP4 mvhd cut short", "a.m4a", mp4File(mp4Box("mvhd", []byte{1, 0, 0, 0}))},
+		{"MP4 moov larger than the file", "a.m4a", []byte("\x03\xff\xff\xffmoov")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, tt.file, tt.data)
+
+			var err error
+			if n := allocated(func() { _, err = ReadDuration(path) }); n > maxParseAlloc {
+				t.Errorf("allocated %d bytes", n)
+			}
+			if err == nil {
+				t.Fatal("read a duration from a broken file")
+			}
+		})
+	}
+}
+
+const flacPadding = 1
+
+// MPEG-1 Layer III at 128 kbit/s and 44.1 kHz, and MPEG-2 Layer III at
+// 64 kbit/s, 22.05 kHz and mono
+var (
+	mp3Stereo128k = []byte{0xFF, 0xFB, 0x90, 0x00}
+	mp3Mono64k    = []byte{0xFF, 0xF3, 0x80, 0xC0}
+)
+
+func mp3Frames(header []byte, n int) []byte {
+	frame, _ := parseMPEGHeader(header)
+	data := make([]byte, 0, n*frame.length())
+	for i := 0; i < n; i++ {
+		data = append(data, header...)
+		data = append(data, make([]byte, frame.length()-len(header))...)
+	}
+	return data
+}
+
+// mp3VBRFrame is a frame carrying a Xing, Info or VBRI header at offset
+// that counts frames.
+func mp3VBRFrame(header []byte, offset int, tag string, frames uint32) []byte {
+	data := mp3Frames(header, 1)
+	copy(data[offset:], tag)
+	if tag == "VBRI" {
+		binary.BigEndian.PutUint32(data[offset+14:], frames)
+	} else {
+		binary.BigEndian.PutUint32(data[offset+4:], 0x01) // frame count present
+		binary.BigEndian.PutUint32(data[offset+8:], frames)
+	}
+	return data
+}
+
+// flacStreamInfoData describes 16-bit stereo.
+func flacStreamInfoData(sampleRate int, samples int64) []byte {
+	data := make([]byte, 34)
+	v := uint64(sampleRate)<<44 | 1<<41 | 15<<36 | uint64(samples)
+	binary.BigEndian.PutUint64(data[10:18], v)
+	return data
+}
+
+// wavFmt is a fmt chunk for 16-bit stereo at 44.1 kHz that claims size
+// bytes, only the first 16 of which are filled in.
+func wavFmt(size uint32) []byte {
+	chunk := []byte("fmt ")
+	chunk = binary.LittleEndian.AppendUint32(chunk, size)
+	fields := binary.LittleEndian.AppendUint16(nil, 1) // PCM
+	fields = binary.LittleEndian.AppendUint16(fields, 2)
+	fields = binary.LittleEndian.AppendUint32(fields, 44100)
+	fields = binary.LittleEndian.AppendUint32(fields, 44100*4)
+	fields = binary.LittleEndian.AppendUint16(fields, 4)
+	fields = binary.LittleEndian.AppendUint16(fields, 16)
+	if size < uint32(len(fields)) {
+		return append(chunk, fields[:size]...)
+	}
+	if size <= 1<<16 {
+		fields = append(fields, make([]byte, int(size)-len(fields)+int(size%2))...)
+	}
+	return append(chunk, fields...)
+}
+
+func wavFile(fmtChunk []byte, samples int) []byte {
+	data := append([]byte("RIFF\x00\x00\x00\x00WAVE"), fmtChunk...)
+	data = append(data, "data"...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(samples))
+	return append(data, make([]byte, samples)...)
+}
+
+func vorbisIdent(sampleRate uint32) []byte {
+	ident := []byte("\x01vorbis\x00\x00\x00\x00\x02")
+	ident = binary.LittleEndian.AppendUint32(ident, sampleRate)
+	return append(ident, make([]byte, 14)...)
+}
+
+func opusIdent(preSkip uint16) []byte {
+	ident := []byte("OpusHead\x01\x02")
+	ident = binary.LittleEndian.AppendUint16(ident, preSkip)
+	return append(ident, make([]byte, 7)...)
+}
+
+// oggSerial moves an Ogg page to another logical stream.
+func oggSerial(page []byte, serial uint32) []byte {
+	binary.LittleEndian.PutUint32(page[14:18], serial)
+	return page
+}
+
+// mp4TimedData is the payload of an mvhd or mdhd box.
+func mp4TimedData(version byte, timescale uint32, duration uint64) []byte {
+	data := []byte{version, 0, 0, 0}
+	if version == 1 {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint32(data, timescale)
+		data = binary.BigEndian.AppendUint64(data, duration)
+	} else {
+		data = append(data, make([]byte, 8)...)
+		data = binary.BigEndian.AppendUint32(data, timescale)
+		data = binary.BigEndian.AppendUint32(data, uint32(duration))
+	}
+	return append(data, make([]byte, 80)...)
+}
+
+func mp4File(moov ...[]byte) []byte {
+	data := append(mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00")), mp4Box("mdat", make([]byte, 64))...)
+	return append(data, mp4Box("moov", moov...)...)
+}
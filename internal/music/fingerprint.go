@@ -0,0 +1,245 @@
+package music
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fingerprintSample is how much of the audio payload is hashed from its
+// start, middle and end. Hashing every byte of a large library would make
+// scans far slower for no practical gain in uniqueness.
+const fingerprintSample = 64 * 1024
+
+// Fingerprint derives a track ID from the audio payload of the file at
+// path. Tags are excluded wherever the format allows, so the ID survives
+// retagging as well as renames and moves.
+func Fingerprint(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	start, end := int64(0), info.Size()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		start, end = mp3Payload(f, end)
+	case ".flac":
+		if offset, err := flacAudioOffset(f); err == nil {
+			start = offset
+		}
+	case ".wav":
+		if offset, size, _, err := wavDataChunk(f); err == nil {
+			start, end = offset, offset+size
+		}
+	case ".m4a", ".mp4", ".mov":
+		if offset, size, err := mp4MediaData(f); err == nil {
+			start, end = offset, offset+size
+		}
+	case ".ogg":
+		// Page headers carry sequence numbers that shift when the comment
+		// header grows, so Ogg streams are hashed page body by page body
+		if err := hashOggAudio(h, f); err == nil {
+			return encodeFingerprint(h), nil
+		}
+		h.Reset()
+	}
+
+	if err := hashSamples(h, f, start, end); err != nil {
+		return "", err
+	}
+	return encodeFingerprint(h), nil
+}
+
+func encodeFingerprint(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))[:24]
+}
+
+// pathFingerprint is the fallback ID for files whose content can't be
+// read.
+func pathFingerprint(pathID string) string {
+	h := sha256.New()
+	io.WriteString(h, "path\x00"+pathID)
+	return encodeFingerprint(h)
+}
+
+// duplicateID disambiguates a copy of a file that is already in the
+// catalog by mixing its path into the shared fingerprint.
+func duplicateID(id, pathID string) string {
+	return id + "-" + pathFingerprint(pathID)[:8]
+}
+
+// setID changes the track's ID, keeping its artwork URL in step.
+func (t *Track) setID(id string) {
+	t.ID = id
+	if t.ArtURL != "" {
+		t.ArtURL = artURL(id)
+	}
+}
+
+// hashSamples hashes the length of f[start:end] and, for large payloads,
+// fixed-size samples from its start, middle and end.
+func hashSamples(h hash.Hash, f *os.File, start, end int64) error {
+	if end < start {
+		end = start
+	}
+	length := end - start
+	binary.Write(h, binary.BigEndian, length)
+
+	if length <= 3*fingerprintSample {
+		_, err := io.Copy(h, io.NewSectionReader(f, start, length))
+		return err
+	}
+	for _, offset := range []int64{start, start + (length-fingerprintSample)/2, end - fingerprintSample} {
+		if _, err := io.Copy(h, io.NewSectionReader(f, offset, fingerprintSample)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mp3Payload returns the range of an MP3 file between its leading ID3v2
+// tag and any trailing APEv2 and ID3v1 tags.
+func mp3Payload(f *os.File, size int64) (start, end int64) {
+	end = size
+	if _, tagSize, err := readID3v2(f); err == nil {
+		start = tagSize
+	}
+
+	trailer := make([]byte, 128)
+	if end-start >= 128 {
+		if _, err := f.ReadAt(trailer, end-128); err == nil && string(trailer[0:3]) == "TAG" {
+			end -= 128
+		}
+	}
+
+	footer := make([]byte, 32)
+	if end-start >= 32 {
+		if _, err := f.ReadAt(footer, end-32); err == nil && string(footer[0:8]) == "APETAGEX" {
+			tagSize := int64(binary.LittleEndian.Uint32(footer[12:16]))
+			if binary.LittleEndian.Uint32(footer[20:24])&(1<<31) != 0 {
+				tagSize += 32 // header
+			}
+			if tagSize <= end-start {
+				end -= tagSize
+			}
+		}
+	}
+
+	if start > end {
+		start = end
+	}
+	return start, end
+}
+
+// flacAudioOffset returns the offset of the first audio frame, just past
+// the metadata blocks.
+func flacAudioOffset(f *os.File) (int64, error) {
+	if err := walkFLACMetadata(f, func(byte, []byte) bool { return true }); err != nil {
+		return 0, err
+	}
+	return f.Seek(0, io.SeekCurrent)
+}
+
+// mp4MediaData returns the range of the first non-empty mdat atom.
+// Metadata lives in moov, so the samples are untouched by retagging.
+func mp4MediaData(f *os.File) (offset, size int64, err error) {
+	end, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	header := make([]byte, 16)
+	for pos := int64(0); pos+8 <= end; {
+		if _, err := f.ReadAt(header[:8], pos); err != nil {
+			return 0, 0, err
+		}
+		atomSize := int64(binary.BigEndian.Uint32(header[0:4]))
+		kind := string(header[4:8])
+		headerLen := int64(8)
+
+		switch atomSize {
+		case 0:
+			atomSize = end - pos
+		case 1:
+			if _, err := f.ReadAt(header[8:16], pos+8); err != nil {
+				return 0, 0, err
+			}
+			atomSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerLen = 16
+		}
+		if atomSize < headerLen || pos+atomSize > end {
+			break
+		}
+
+		if kind == "mdat" && atomSize > headerLen {
+			return pos + headerLen, atomSize - headerLen, nil
+		}
+		pos += atomSize
+	}
+	return 0, 0, fmt.Errorf("mdat atom not found")
+}
+
+// hashOggAudio hashes the bodies of the first audio pages of the first
+// logical stream, plus its final granule position. Header pages all have a
+// granule position of zero.
+func hashOggAudio(h hash.Hash, f *os.File) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var serial uint32
+	hashed := int64(0)
+	for page := 0; hashed < 2*fingerprintSample; page++ {
+		header, err := readOggPageHeader(f)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if page == 0 {
+			serial = header.serial
+		}
+
+		bodySize := int64(0)
+		for _, segment := range header.segments {
+			bodySize += int64(segment)
+		}
+		if header.serial != serial || header.granule == 0 {
+			if _, err := f.Seek(bodySize, io.SeekCurrent); err != nil {
+				return err
+			}
+			continue
+		}
+
+		n, err := io.CopyN(h, f, bodySize)
+		hashed += n
+		if err != nil {
+			break
+		}
+	}
+	if hashed == 0 {
+		return fmt.Errorf("no audio pages found")
+	}
+
+	granule, err := lastOggGranule(f, serial)
+	if err != nil {
+		return err
+	}
+	return binary.Write(h, binary.BigEndian, granule)
+}
@@ -0,0 +1,262 @@
+package music
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// noise is n bytes of made-up audio, different for every seed.
+func noise(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func quietLogs(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+func TestFingerprintSurvivesRetagging(t *testing.T) {
+	audio := noise(1, 300*1024)
+	streamInfo := flacBlock(flacStreamInfo, flacStreamInfoData(44100, 44100*60))
+	longComment := "COMMENT=" + strings.Repeat("retagged ", 100)
+
+	tests := []struct {
+		file          string
+		before, after []byte
+	}{
+		{
+			file:   "a.mp3",
+			before: append(id3v2Tag(3, id3v2Frame(3, "TIT2", id3v2Text("Title"))), audio...),
+			after: bytes.Join([][]byte{
+				id3v2Tag(4, id3v2Frame(4, "TIT2", id3v2Text("A Much Longer Title")), id3v2Frame(4, "TPE1", id3v2Text("Artist"))),
+				audio,
+				id3v1Tag("Title", "Artist", "Album", "1999", 1, 17),
+			}, nil),
+		},
+		{
+			file:   "a.flac",
+			before: append(flacStream(streamInfo, flacBlock(flacVorbisComment, vorbisComment("test", "TITLE=Title"))), audio...),
+			after: append(flacStream(streamInfo,
+				flacBlock(flacVorbisComment, vorbisComment("test", "TITLE=A Much Longer Title", longComment)),
+				flacBlock(flacPicture, flacPictureData(pictureFrontCover, "image/png", pngData))), audio...),
+		},
+		{
+			file:   "a.wav",
+			before: wavWithData(nil, audio),
+			after:  wavWithData([]byte("LIST\x0c\x00\x00\x00INFOINAM\x00\x00\x00\x00"), audio),
+		},
+		{
+			file: "a.m4a",
+			before: bytes.Join([][]byte{
+				mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00")),
+				mp4Box("moov", mp4Box("udta", mp4Box("meta", []byte{0, 0, 0, 0}, mp4Box("ilst", mp4Item("\xa9nam", []byte("Title")))))),
+				mp4Box("mdat", audio),
+			}, nil),
+			after: bytes.Join([][]byte{
+				mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00")),
+				mp4Box("moov", mp4Box("udta", mp4Box("meta", []byte{0, 0, 0, 0}, mp4Box("ilst",
+					mp4Item("\xa9nam", []byte("A Much Longer Title")), mp4Item("covr", pngData))))),
+				mp4Box("mdat", audio),
+			}, nil),
+		},
+		{
+			file:   "a.ogg",
+			before: oggFile(vorbisComment("test", "TITLE=Title"), audio),
+			after:  oggFile(vorbisComment("test", "TITLE=A Much Longer Title", longComment), audio),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := writeFile(t, tt.file, tt.before)
+			before, err := Fingerprint(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.after, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			after, err := Fingerprint(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after != before {
+				t.Fatalf("fingerprint changed from %s to %s by retagging", before, after)
+			}
+
+			// The audio itself still counts
+			other := bytes.Replace(tt.after, audio[:1024], noise(2, 1024), 1)
+			if err := os.WriteFile(path, other, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if changed, _ := Fingerprint(path); changed == before {
+				t.Fatal("fingerprint unchanged by different audio")
+			}
+		})
+	}
+}
+
+func TestTrackIDSurvivesRetaggingAndRenaming(t *testing.T) {
+	quietLogs(t)
+	dir := t.TempDir()
+	indexPath := filepath.Join(t.TempDir(), "catalog.gob")
+	audio := noise(1, 64*1024)
+
+	oldPath := filepath.Join(dir, "old", "a.mp3")
+	os.MkdirAll(filepath.Dir(oldPath), 0o755)
+	if err := os.WriteFile(oldPath, append(id3v2Tag(3, id3v2Frame(3, "TIT2", id3v2Text("Title"))), audio...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(dir, indexPath)
+	id := onlyTrack(t, s).ID
+
+	// Retagged in place
+	retagged := append(id3v2Tag(3, id3v2Frame(3, "TIT2", id3v2Text("New Title")), id3v2Frame(3, "TPE1", id3v2Text("Artist"))), audio...)
+	if err := os.WriteFile(oldPath, retagged, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.RescanCatalog()
+	if track := onlyTrack(t, s); track.ID != id || track.Title != "New Title" {
+		t.Fatalf("after retagging, track = %s %q, want %s %q", track.ID, track.Title, id, "New Title")
+	}
+
+	// Renamed, and picked up by a rescan
+	newPath := filepath.Join(dir, "new", "b.mp3")
+	os.MkdirAll(filepath.Dir(newPath), 0o755)
+	if err := os.Rename(oldPath, newPath); err != nil {
+		t.Fatal(err)
+	}
+	s.RescanCatalog()
+	if track := onlyTrack(t, s); track.ID != id || track.Path != newPath {
+		t.Fatalf("after renaming, track = %s at %s, want %s at %s", track.ID, track.Path, id, newPath)
+	}
+
+	// Renamed again, and picked up by the watcher
+	lastPath := filepath.Join(dir, "c.mp3")
+	if err := os.Rename(newPath, lastPath); err != nil {
+		t.Fatal(err)
+	}
+	s.applyPathChanges([]string{newPath, lastPath})
+	if track := onlyTrack(t, s); track.ID != id || track.Path != lastPath {
+		t.Fatalf("after moving, track = %s at %s, want %s at %s", track.ID, track.Path, id, lastPath)
+	}
+
+	// Links from before content IDs name the path the file had then
+	for _, s := range []*Service{s, NewService(dir, indexPath)} {
+		for _, pathID := range []string{"old/a.mp3", "new/b.mp3", "c.mp3"} {
+			track, err := s.GetTrack(pathID)
+			if err != nil {
+				t.Fatalf("GetTrack(%q): %v", pathID, err)
+			}
+			if track.ID != id || track.Path != lastPath {
+				t.Fatalf("GetTrack(%q) = %s at %s, want %s at %s", pathID, track.ID, track.Path, id, lastPath)
+			}
+		}
+	}
+	if _, err := s.GetTrack("missing.mp3"); err == nil {
+		t.Fatal("GetTrack found a path that was never in the library")
+	}
+}
+
+func TestSampledDuplicatesGetTheirOwnIDs(t *testing.T) {
+	quietLogs(t)
+	dir := t.TempDir()
+
+	// The files differ only between the sampled windows
+	audio := noise(1, 1<<20)
+	other := bytes.Clone(audio)
+	copy(other[200*1024:], noise(2, 1024))
+
+	a := filepath.Join(dir, "a.wav")
+	b := filepath.Join(dir, "b.wav")
+	for path, audio := range map[string][]byte{a: audio, b: other} {
+		if err := os.WriteFile(path, wavWithData(nil, audio), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fa, _ := Fingerprint(a)
+	fb, _ := Fingerprint(b)
+	if fa != fb {
+		t.Fatalf("fingerprints %s and %s differ, want the sampled windows alike", fa, fb)
+	}
+
+	s := NewService(dir, "")
+	ids := trackIDs(t, s, 2)
+	if ids[a] == ids[b] || !strings.HasPrefix(ids[b], fa+"-") {
+		t.Fatalf("IDs = %v, want the second file told apart from %s", ids, fa)
+	}
+
+	// A third copy arriving through the watcher gets its own too
+	c := filepath.Join(dir, "c.wav")
+	if err := os.WriteFile(c, wavWithData(nil, audio), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.applyPathChanges([]string{c})
+	ids = trackIDs(t, s, 3)
+	if ids[c] == ids[a] || ids[c] == ids[b] || !strings.HasPrefix(ids[c], fa+"-") {
+		t.Fatalf("IDs = %v, want the third copy told apart", ids)
+	}
+
+	// A duplicate that moves keeps its ID
+	d := filepath.Join(dir, "d.wav")
+	before := ids[b]
+	if err := os.Rename(b, d); err != nil {
+		t.Fatal(err)
+	}
+	s.applyPathChanges([]string{b, d})
+	if ids = trackIDs(t, s, 3); ids[d] != before {
+		t.Fatalf("IDs = %v, want %s to keep %s", ids, d, before)
+	}
+}
+
+func onlyTrack(t *testing.T, s *Service) Track {
+	t.Helper()
+	catalog := s.GetCatalog()
+	if len(catalog) != 1 {
+		t.Fatalf("catalog has %d tracks, want 1", len(catalog))
+	}
+	return catalog[0]
+}
+
+// trackIDs returns the catalog's track IDs by path.
+func trackIDs(t *testing.T, s *Service, n int) map[string]string {
+	t.Helper()
+	ids := make(map[string]string)
+	for _, track := range s.GetCatalog() {
+		ids[track.Path] = track.ID
+	}
+	if len(ids) != n {
+		t.Fatalf("catalog has %d tracks, want %d", len(ids), n)
+	}
+	return ids
+}
+
+// wavWithData is a WAV holding audio, with chunks before its data.
+func wavWithData(chunks, audio []byte) []byte {
+	data := append([]byte("RIFF\x00\x00\x00\x00WAVE"), wavFmt(16)...)
+	data = append(data, chunks...)
+	data = append(data, "data"...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(audio)))
+	return append(data, audio...)
+}
+
+// oggFile is a Vorbis stream with the given comment header, and audio on
+// pages of its own.
+func oggFile(comment, audio []byte) []byte {
+	data := oggPage(0x02, 0, vorbisIdent(44100))
+	data = append(data, oggPage(0, 0, append([]byte("\x03vorbis"), comment...))...)
+	for i := 0; len(audio) > 0; i++ {
+		n := min(len(audio), 4000)
+		data = append(data, oggPage(0, int64(i+1)*4096, audio[:n])...)
+		audio = audio[n:]
+	}
+	return data
+}
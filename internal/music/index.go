@@ -0,0 +1,116 @@
+package music
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// indexVersion must be bumped whenever the way tracks are built from files
+// changes, so stale indexes are discarded instead of serving old metadata.
+const indexVersion = 3
+
+// catalogIndex is the on-disk cache of parsed track metadata, keyed by
+// file path.
+type catalogIndex struct {
+	Version    int
+	Generation uint64
+	LastScan   time.Time
+	Entries    map[string]indexEntry
+
+	// Redirects maps path-based track IDs, including those of files that
+	// have since moved, to content-based ones
+	Redirects map[string]string
+}
+
+// indexEntry is a cached track together with the file attributes it was
+// parsed from.
+type indexEntry struct {
+	ModTime int64 // unix nanoseconds
+	Size    int64
+	Track   Track
+}
+
+// Status describes the state of the catalog and its index.
+type Status struct {
+	Tracks     int       `json:"tracks"`
+	Generation uint64    `json:"generation"`
+	LastScan   time.Time `json:"last_scan"`
+	Indexed    bool      `json:"indexed"`  // whether the index is persisted to disk
+	Watching   bool      `json:"watching"` // whether the library watcher is running
+}
+
+func newCatalogIndex() *catalogIndex {
+	return &catalogIndex{
+		Version:   indexVersion,
+		Entries:   make(map[string]indexEntry),
+		Redirects: make(map[string]string),
+	}
+}
+
+func loadCatalogIndex(path string) (*catalogIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var idx catalogIndex
+	if err := gob.NewDecoder(f).Decode(&idx); err != nil {
+		return nil, err
+	}
+	if idx.Version != indexVersion {
+		return nil, fmt.Errorf("index version %d, want %d", idx.Version, indexVersion)
+	}
+	if idx.Entries == nil {
+		idx.Entries = make(map[string]indexEntry)
+	}
+	if idx.Redirects == nil {
+		idx.Redirects = make(map[string]string)
+	}
+	return &idx, nil
+}
+
+// save writes the index to path atomically by writing a temporary file and
+// renaming it into place.
+func (idx *catalogIndex) save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(idx); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// lookup returns the cached track for path if the file has not changed
+// since it was indexed.
+func (idx *catalogIndex) lookup(path string, info fs.FileInfo) (Track, bool) {
+	entry, ok := idx.Entries[path]
+	if !ok || entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano() {
+		return Track{}, false
+	}
+	return entry.Track, true
+}
+
+func newIndexEntry(track Track, info fs.FileInfo) indexEntry {
+	return indexEntry{
+		ModTime: info.ModTime().UnixNano(),
+		Size:    info.Size(),
+		Track:   track,
+	}
+}
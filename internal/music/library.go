@@ -0,0 +1,328 @@
+package music
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	unknownAlbum   = "Unknown Album"
+	unknownArtist  = "Unknown Artist"
+	variousArtists = "Various Artists"
+)
+
+// Album groups the tracks that share an album title and album artist.
+type Album struct {
+	ID         string  `json:"id"`
+	Title      string  `json:"title"`
+	Artist     string  `json:"artist"`
+	ArtistID   string  `json:"artist_id"`
+	Year       int     `json:"year,omitempty"`
+	Genre      string  `json:"genre,omitempty"`
+	TrackCount int     `json:"track_count"`
+	Duration   int     `json:"duration"`    // in seconds
+	DurationMs int64   `json:"duration_ms"` // in milliseconds
+	Tracks     []Track `json:"tracks,omitempty"`
+}
+
+// Artist groups everything credited to one artist, either as track artist
+// or album artist.
+type Artist struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	AlbumCount int     `json:"album_count"`
+	TrackCount int     `json:"track_count"`
+	Duration   int     `json:"duration"`    // in seconds
+	DurationMs int64   `json:"duration_ms"` // in milliseconds
+	Albums     []Album `json:"albums,omitempty"`
+	Tracks     []Track `json:"tracks,omitempty"`
+}
+
+// library holds the album and artist aggregates built from the catalog.
+type library struct {
+	albums  map[string]*Album
+	artists map[string]*Artist
+}
+
+func aggregateID(parts ...string) string {
+	h := sha1.New()
+	for _, part := range parts {
+		h.Write([]byte(normalise(part)))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// albumArtist is the artist an album is filed under: the album artist tag
+// when present, otherwise the track artist.
+func albumArtist(track *Track) string {
+	if track.AlbumArtist != "" {
+		return track.AlbumArtist
+	}
+	return track.Artist
+}
+
+func sortAlbumTracks(tracks []Track) {
+	sort.SliceStable(tracks, func(i, j int) bool {
+		if tracks[i].DiscNumber != tracks[j].DiscNumber {
+			return tracks[i].DiscNumber < tracks[j].DiscNumber
+		}
+		if tracks[i].TrackNumber != tracks[j].TrackNumber {
+			return tracks[i].TrackNumber < tracks[j].TrackNumber
+		}
+		return compareStrings(tracks[i].Title, tracks[j].Title) < 0
+	})
+}
+
+func newLibrary(tracks map[string]Track) *library {
+	lib := &library{
+		albums:  make(map[string]*Album),
+		artists: make(map[string]*Artist),
+	}
+
+	// Group tracks into albums by title and album artist, falling back to
+	// the track artist, so albums that merely share a title stay apart.
+	// Tracks without an album artist tag that share a title and a directory
+	// are one compilation, even though their track artists differ.
+	// Untagged tracks are kept apart per artist rather than lumped into one
+	// huge "Unknown Album".
+	groups := newUnionFind()
+	byTitle := make(map[string][]Track)
+	compilationDirs := make(map[string]string)
+	for _, track := range tracks {
+		key := normalise(track.Album) + "\x00" + normalise(albumArtist(&track))
+		byTitle[key] = append(byTitle[key], track)
+		groups.add(key)
+		if track.AlbumArtist == "" && track.Album != unknownAlbum {
+			dirKey := normalise(track.Album) + "\x00" + filepath.Dir(track.Path)
+			if other, ok := compilationDirs[dirKey]; ok {
+				groups.union(key, other)
+			} else {
+				compilationDirs[dirKey] = key
+			}
+		}
+	}
+
+	merged := make(map[string][]Track)
+	for key, group := range byTitle {
+		root := groups.find(key)
+		merged[root] = append(merged[root], group...)
+	}
+
+	for _, group := range merged {
+		// Order by path first, so the album looks the same on every scan
+		sort.Slice(group, func(i, j int) bool { return group[i].Path < group[j].Path })
+
+		artist := albumArtist(&group[0])
+		for i := range group[1:] {
+			if normalise(albumArtist(&group[i+1])) != normalise(artist) {
+				artist = variousArtists
+				break
+			}
+		}
+
+		// A compilation found by its directory could share its title
+		// with another, so its directory tells them apart
+		id := aggregateID(group[0].Album, artist)
+		if artist == variousArtists && group[0].AlbumArtist == "" {
+			id = aggregateID(group[0].Album, artist, filepath.Dir(group[0].Path))
+		}
+
+		album := &Album{
+			ID:       id,
+			Title:    group[0].Album,
+			Artist:   artist,
+			ArtistID: aggregateID(artist),
+			Tracks:   group,
+		}
+		for _, track := range group {
+			if track.Year != 0 && (album.Year == 0 || track.Year < album.Year) {
+				album.Year = track.Year
+			}
+			if album.Genre == "" {
+				album.Genre = track.Genre
+			}
+			album.DurationMs += track.DurationMs
+		}
+		album.TrackCount = len(group)
+		album.Duration = int((album.DurationMs + 500) / 1000)
+		sortAlbumTracks(album.Tracks)
+
+		lib.albums[album.ID] = album
+	}
+
+	// Credit each track to its artist and album artist
+	artistAlbums := make(map[string]map[string]bool)
+	credit := func(name string, track Track, albumID string) {
+		if name == "" || name == variousArtists || name == unknownArtist {
+			return
+		}
+		id := aggregateID(name)
+		artist, ok := lib.artists[id]
+		if !ok {
+			artist = &Artist{ID: id, Name: name}
+			lib.artists[id] = artist
+			artistAlbums[id] = make(map[string]bool)
+		}
+		artist.Tracks = append(artist.Tracks, track)
+		artistAlbums[id][albumID] = true
+	}
+
+	for _, album := range lib.albums {
+		for _, track := range album.Tracks {
+			credit(track.Artist, track, album.ID)
+			if normalise(album.Artist) != normalise(track.Artist) {
+				credit(album.Artist, track, album.ID)
+			}
+		}
+	}
+
+	for id, artist := range lib.artists {
+		for albumID := range artistAlbums[id] {
+			artist.Albums = append(artist.Albums, *lib.albums[albumID])
+		}
+		for _, track := range artist.Tracks {
+			artist.DurationMs += track.DurationMs
+		}
+		artist.AlbumCount = len(artist.Albums)
+		artist.TrackCount = len(artist.Tracks)
+		artist.Duration = int((artist.DurationMs + 500) / 1000)
+
+		sortAlbums(artist.Albums)
+		sort.SliceStable(artist.Tracks, func(i, j int) bool {
+			a, b := &artist.Tracks[i], &artist.Tracks[j]
+			if c := compareStrings(a.Album, b.Album); c != 0 {
+				return c < 0
+			}
+			if a.DiscNumber != b.DiscNumber {
+				return a.DiscNumber < b.DiscNumber
+			}
+			return a.TrackNumber < b.TrackNumber
+		})
+	}
+
+	return lib
+}
+
+// unionFind merges groups of keys that belong together.
+type unionFind map[string]string
+
+func newUnionFind() unionFind {
+	return make(unionFind)
+}
+
+func (u unionFind) add(key string) {
+	if _, ok := u[key]; !ok {
+		u[key] = key
+	}
+}
+
+func (u unionFind) find(key string) string {
+	for u[key] != key {
+		u[key] = u[u[key]]
+		key = u[key]
+	}
+	return key
+}
+
+func (u unionFind) union(a, b string) {
+	u[u.find(a)] = u.find(b)
+}
+
+// sortAlbums orders albums by year, then title.
+func sortAlbums(albums []Album) {
+	sort.Slice(albums, func(i, j int) bool {
+		if albums[i].Year != albums[j].Year {
+			return albums[i].Year < albums[j].Year
+		}
+		return compareStrings(albums[i].Title, albums[j].Title) < 0
+	})
+}
+
+// summary returns a copy of the album without its track list.
+func (a *Album) summary() Album {
+	album := *a
+	album.Tracks = nil
+	return album
+}
+
+// summary returns a copy of the artist without albums or tracks.
+func (a *Artist) summary() Artist {
+	artist := *a
+	artist.Albums = nil
+	artist.Tracks = nil
+	return artist
+}
+
+// GetAlbums lists every album, without track lists, ordered by artist and
+// year.
+func (s *Service) GetAlbums() []Album {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	albums := make([]Album, 0, len(s.library.albums))
+	for _, album := range s.library.albums {
+		albums = append(albums, album.summary())
+	}
+	sort.SliceStable(albums, func(i, j int) bool {
+		if c := compareStrings(albums[i].Artist, albums[j].Artist); c != 0 {
+			return c < 0
+		}
+		if albums[i].Year != albums[j].Year {
+			return albums[i].Year < albums[j].Year
+		}
+		return compareStrings(albums[i].Title, albums[j].Title) < 0
+	})
+	return albums
+}
+
+// GetAlbum returns an album with its tracks in disc and track order.
+func (s *Service) GetAlbum(id string) (*Album, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	album, exists := s.library.albums[id]
+	if !exists {
+		return nil, fmt.Errorf("album not found")
+	}
+	result := *album
+	result.Tracks = append([]Track(nil), album.Tracks...)
+	return &result, nil
+}
+
+// GetArtists lists every artist, without albums or tracks, ordered by name.
+func (s *Service) GetArtists() []Artist {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	artists := make([]Artist, 0, len(s.library.artists))
+	for _, artist := range s.library.artists {
+		artists = append(artists, artist.summary())
+	}
+	sort.Slice(artists, func(i, j int) bool {
+		return compareStrings(artists[i].Name, artists[j].Name) < 0
+	})
+	return artists
+}
+
+// GetArtist returns an artist with album summaries and all their tracks.
+func (s *Service) GetArtist(id string) (*Artist, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	artist, exists := s.library.artists[id]
+	if !exists {
+		return nil, fmt.Errorf("artist not found")
+	}
+
+	result := *artist
+	result.Albums = make([]Album, len(artist.Albums))
+	for i := range artist.Albums {
+		result.Albums[i] = artist.Albums[i].summary()
+	}
+	result.Tracks = append([]Track(nil), artist.Tracks...)
+	return &result, nil
+}
@@ -0,0 +1,111 @@
+package music
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// testLibrary builds a library from tracks, giving each an ID.
+func testLibrary(tracks ...Track) *Service {
+	byID := make(map[string]Track)
+	for i, track := range tracks {
+		track.ID = fmt.Sprint("t", i)
+		byID[track.ID] = track
+	}
+	return &Service{library: newLibrary(byID)}
+}
+
+// albumTitles lists the albums as "title by artist".
+func albumTitles(s *Service) []string {
+	var titles []string
+	for _, album := range s.GetAlbums() {
+		titles = append(titles, album.Title+" by "+album.Artist)
+	}
+	return titles
+}
+
+func TestAlbumsSharingATitle(t *testing.T) {
+	tests := []struct {
+		name   string
+		tracks []Track
+	}{
+		{"in their own directories", []Track{
+			{Title: "Bohemian Rhapsody", Artist: "Queen", Album: "Greatest Hits", Path: "/music/Queen/Greatest Hits/01.mp3"},
+			{Title: "Another One Bites the Dust", Artist: "Queen", Album: "Greatest Hits", Path: "/music/Queen/Greatest Hits/02.mp3"},
+			{Title: "Hotel California", Artist: "Eagles", Album: "Greatest Hits", Path: "/music/Eagles/Greatest Hits/01.mp3"},
+		}},
+		{"with album artists, in one directory", []Track{
+			{Title: "Bohemian Rhapsody", Artist: "Queen", AlbumArtist: "Queen", Album: "Greatest Hits", Path: "/music/Downloads/01.mp3"},
+			{Title: "Another One Bites the Dust", Artist: "Queen", AlbumArtist: "Queen", Album: "Greatest Hits", Path: "/music/Downloads/02.mp3"},
+			{Title: "Hotel California", Artist: "Eagles", AlbumArtist: "Eagles", Album: "Greatest Hits", Path: "/music/Downloads/03.mp3"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testLibrary(tt.tracks...)
+			albums := s.GetAlbums()
+			want := []string{"Greatest Hits by Eagles", "Greatest Hits by Queen"}
+			if got := albumTitles(s); !slices.Equal(got, want) {
+				t.Fatalf("albums = %q, want %q", got, want)
+			}
+			if albums[0].ID == albums[1].ID || albums[0].TrackCount != 1 || albums[1].TrackCount != 2 {
+				t.Fatalf("albums = %+v, want one of each artist's tracks", albums)
+			}
+		})
+	}
+}
+
+func TestCompilation(t *testing.T) {
+	s := testLibrary(
+		Track{Title: "One", Artist: "Alpha", AlbumArtist: "DJ Mix", Album: "Summer Hits", DiscNumber: 1, TrackNumber: 1, Path: "/music/Summer Hits/CD1/01.mp3"},
+		Track{Title: "Two", Artist: "Beta", AlbumArtist: "DJ Mix", Album: "Summer Hits", DiscNumber: 1, TrackNumber: 2, Path: "/music/Summer Hits/CD1/02.mp3"},
+		Track{Title: "Three", Artist: "Gamma", AlbumArtist: "DJ Mix", Album: "Summer Hits", DiscNumber: 2, TrackNumber: 1, Path: "/music/Summer Hits/CD2/01.mp3"},
+	)
+
+	albums := s.GetAlbums()
+	if len(albums) != 1 || albums[0].Artist != "DJ Mix" || albums[0].TrackCount != 3 {
+		t.Fatalf("albums = %q, want one compilation of 3 tracks", albumTitles(s))
+	}
+
+	// The album artist and each track artist are credited with the album
+	tracks := map[string]int{"Alpha": 1, "Beta": 1, "DJ Mix": 3, "Gamma": 1}
+	var names []string
+	for _, artist := range s.GetArtists() {
+		names = append(names, artist.Name)
+		full, err := s.GetArtist(artist.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(full.Albums) != 1 || full.Albums[0].ID != albums[0].ID || full.TrackCount != tracks[artist.Name] {
+			t.Fatalf("%s has %d albums and %d tracks, want the compilation and %d", artist.Name, len(full.Albums), full.TrackCount, tracks[artist.Name])
+		}
+	}
+	if want := []string{"Alpha", "Beta", "DJ Mix", "Gamma"}; !slices.Equal(names, want) {
+		t.Fatalf("artists = %q, want %q", names, want)
+	}
+}
+
+func TestAlbumTrackOrder(t *testing.T) {
+	// Paths sort differently from discs and tracks
+	s := testLibrary(
+		Track{Title: "2-1", Album: "Album", Artist: "Artist", DiscNumber: 2, TrackNumber: 1, Path: "/music/Album/a.flac"},
+		Track{Title: "1-2", Album: "Album", Artist: "Artist", DiscNumber: 1, TrackNumber: 2, Path: "/music/Album/b.flac"},
+		Track{Title: "1-1", Album: "Album", Artist: "Artist", DiscNumber: 1, TrackNumber: 1, Path: "/music/Album/c.flac"},
+		Track{Title: "2-10", Album: "Album", Artist: "Artist", DiscNumber: 2, TrackNumber: 10, Path: "/music/Album/d.flac"},
+		Track{Title: "2-2", Album: "Album", Artist: "Artist", DiscNumber: 2, TrackNumber: 2, Path: "/music/Album/e.flac"},
+	)
+
+	// Enqueuing an album queues its tracks as GetAlbum returns them
+	album, err := s.GetAlbum(s.GetAlbums()[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, track := range album.Tracks {
+		order = append(order, track.Title)
+	}
+	if want := []string{"1-1", "1-2", "2-1", "2-2", "2-10"}; !slices.Equal(order, want) {
+		t.Fatalf("album tracks = %v, want %v", order, want)
+	}
+}
@@ -0,0 +1,329 @@
+package music
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	DefaultSearchLimit = 50
+	MaxSearchLimit     = 500
+)
+
+// SearchQuery selects and orders tracks from the catalog. Empty fields
+// don't filter.
+type SearchQuery struct {
+	Text    string // free text, every word must match a word prefix
+	Artist  string // exact artist or album artist, case-insensitive
+	Album   string
+	Genre   string
+	IsVideo *bool
+	Sort    string // title, artist, album, year or duration, "-" prefix for descending
+	Limit   int
+	Cursor  string // opaque, from a previous SearchResult
+}
+
+type SearchResult struct {
+	Tracks     []Track `json:"tracks"`
+	Total      int     `json:"total"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// trackIndex holds the in-memory lookup structures over the catalog.
+type trackIndex struct {
+	byID    map[string]Track
+	artists map[string]map[string]bool // normalised value -> track IDs
+	albums  map[string]map[string]bool
+	genres  map[string]map[string]bool
+	words   map[string]map[string]bool
+
+	// sortedWords lets text queries match word prefixes with a binary search
+	sortedWords []string
+}
+
+func newTrackIndex(tracks []Track) *trackIndex {
+	ix := &trackIndex{
+		byID:    make(map[string]Track, len(tracks)),
+		artists: make(map[string]map[string]bool),
+		albums:  make(map[string]map[string]bool),
+		genres:  make(map[string]map[string]bool),
+		words:   make(map[string]map[string]bool),
+	}
+	for _, track := range tracks {
+		ix.add(track)
+	}
+	ix.finish()
+	return ix
+}
+
+func (ix *trackIndex) add(track Track) {
+	if _, exists := ix.byID[track.ID]; exists {
+		ix.remove(track.ID)
+	}
+	ix.byID[track.ID] = track
+
+	addPosting(ix.artists, normalise(track.Artist), track.ID)
+	addPosting(ix.artists, normalise(track.AlbumArtist), track.ID)
+	addPosting(ix.albums, normalise(track.Album), track.ID)
+	addPosting(ix.genres, normalise(track.Genre), track.ID)
+	for _, word := range trackWords(track) {
+		addPosting(ix.words, word, track.ID)
+	}
+}
+
+func (ix *trackIndex) remove(id string) {
+	track, exists := ix.byID[id]
+	if !exists {
+		return
+	}
+	delete(ix.byID, id)
+
+	removePosting(ix.artists, normalise(track.Artist), id)
+	removePosting(ix.artists, normalise(track.AlbumArtist), id)
+	removePosting(ix.albums, normalise(track.Album), id)
+	removePosting(ix.genres, normalise(track.Genre), id)
+	for _, word := range trackWords(track) {
+		removePosting(ix.words, word, id)
+	}
+}
+
+// finish must be called after a batch of adds and removes.
+func (ix *trackIndex) finish() {
+	ix.sortedWords = ix.sortedWords[:0]
+	for word := range ix.words {
+		ix.sortedWords = append(ix.sortedWords, word)
+	}
+	sort.Strings(ix.sortedWords)
+}
+
+func addPosting(postings map[string]map[string]bool, key, id string) {
+	if key == "" {
+		return
+	}
+	ids, ok := postings[key]
+	if !ok {
+		ids = make(map[string]bool)
+		postings[key] = ids
+	}
+	ids[id] = true
+}
+
+func removePosting(postings map[string]map[string]bool, key, id string) {
+	if ids, ok := postings[key]; ok {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(postings, key)
+		}
+	}
+}
+
+func normalise(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// tokenise splits s into lower-case words made of letters and digits.
+func tokenise(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func trackWords(track Track) []string {
+	seen := make(map[string]bool)
+	var words []string
+	for _, field := range []string{track.Title, track.Artist, track.Album, track.AlbumArtist, track.Genre} {
+		for _, word := range tokenise(field) {
+			if !seen[word] {
+				seen[word] = true
+				words = append(words, word)
+			}
+		}
+	}
+	return words
+}
+
+// prefixMatches returns the IDs of tracks containing a word that starts
+// with prefix.
+func (ix *trackIndex) prefixMatches(prefix string) map[string]bool {
+	matches := make(map[string]bool)
+	i := sort.SearchStrings(ix.sortedWords, prefix)
+	for ; i < len(ix.sortedWords) && strings.HasPrefix(ix.sortedWords[i], prefix); i++ {
+		for id := range ix.words[ix.sortedWords[i]] {
+			matches[id] = true
+		}
+	}
+	return matches
+}
+
+// intersect narrows candidates to the IDs in set. A nil candidates map
+// means no restriction yet.
+func intersect(candidates, set map[string]bool) map[string]bool {
+	if candidates == nil {
+		result := make(map[string]bool, len(set))
+		for id := range set {
+			result[id] = true
+		}
+		return result
+	}
+	for id := range candidates {
+		if !set[id] {
+			delete(candidates, id)
+		}
+	}
+	return candidates
+}
+
+var trackSorts = map[string]func(a, b *Track) int{
+	"title": func(a, b *Track) int {
+		return compareStrings(a.Title, b.Title)
+	},
+	"artist": func(a, b *Track) int {
+		if c := compareStrings(a.Artist, b.Artist); c != 0 {
+			return c
+		}
+		if c := compareStrings(a.Album, b.Album); c != 0 {
+			return c
+		}
+		if c := a.DiscNumber - b.DiscNumber; c != 0 {
+			return c
+		}
+		return a.TrackNumber - b.TrackNumber
+	},
+	"album": func(a, b *Track) int {
+		if c := compareStrings(a.Album, b.Album); c != 0 {
+			return c
+		}
+		if c := a.DiscNumber - b.DiscNumber; c != 0 {
+			return c
+		}
+		return a.TrackNumber - b.TrackNumber
+	},
+	"year": func(a, b *Track) int {
+		return a.Year - b.Year
+	},
+	"duration": func(a, b *Track) int {
+		return int(a.DurationMs - b.DurationMs)
+	},
+}
+
+func compareStrings(a, b string) int {
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	offset, err := strconv.Atoi(string(b))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	return offset, nil
+}
+
+// Search returns one page of tracks matching q.
+func (s *Service) Search(q SearchQuery) (*SearchResult, error) {
+	sortKey := strings.TrimPrefix(q.Sort, "-")
+	if sortKey == "" {
+		sortKey = "artist"
+	}
+	compare, ok := trackSorts[sortKey]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %q", q.Sort)
+	}
+	descending := strings.HasPrefix(q.Sort, "-")
+
+	offset, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	s.mu.RLock()
+	ix := s.tracks
+
+	var candidates map[string]bool
+	if q.Artist != "" {
+		candidates = intersect(candidates, ix.artists[normalise(q.Artist)])
+	}
+	if q.Album != "" {
+		candidates = intersect(candidates, ix.albums[normalise(q.Album)])
+	}
+	if q.Genre != "" {
+		candidates = intersect(candidates, ix.genres[normalise(q.Genre)])
+	}
+	for _, word := range tokenise(q.Text) {
+		candidates = intersect(candidates, ix.prefixMatches(word))
+	}
+
+	var matches []Track
+	if candidates == nil {
+		matches = make([]Track, 0, len(ix.byID))
+		for _, track := range ix.byID {
+			matches = append(matches, track)
+		}
+	} else {
+		matches = make([]Track, 0, len(candidates))
+		for id := range candidates {
+			matches = append(matches, ix.byID[id])
+		}
+	}
+	s.mu.RUnlock()
+
+	if q.IsVideo != nil {
+		filtered := matches[:0]
+		for _, track := range matches {
+			if track.IsVideo == *q.IsVideo {
+				filtered = append(filtered, track)
+			}
+		}
+		matches = filtered
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		c := compare(&matches[i], &matches[j])
+		if c == 0 {
+			// Keep pages stable across requests
+			c = strings.Compare(matches[i].ID, matches[j].ID)
+		}
+		if descending {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	result := &SearchResult{
+		Tracks: make([]Track, 0),
+		Total:  len(matches),
+	}
+	if offset < len(matches) {
+		end := offset + limit
+		if end > len(matches) {
+			end = len(matches)
+		}
+		result.Tracks = matches[offset:end]
+		if end < len(matches) {
+			result.NextCursor = encodeCursor(end)
+		}
+	}
+	return result, nil
+}
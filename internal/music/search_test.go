@@ -0,0 +1,150 @@
+package music
+
+import (
+	"encoding/base64"
+	"slices"
+	"testing"
+)
+
+var searchTracks = []Track{
+	{ID: "a", Title: "Hotel California", Artist: "Eagles", Album: "Hotel California", TrackNumber: 1, Year: 1976, DurationMs: 390000},
+	{ID: "b", Title: "New Kid in Town", Artist: "Eagles", Album: "Hotel California", TrackNumber: 2, Year: 1976, DurationMs: 304000},
+	{ID: "c", Title: "Take It Easy", Artist: "Eagles", Album: "Eagles", TrackNumber: 1, Year: 1972, DurationMs: 210000},
+	{ID: "d", Title: "California Dreamin'", Artist: "The Mamas & the Papas", Album: "If You Can Believe Your Eyes and Ears", TrackNumber: 2, Year: 1966, DurationMs: 162000},
+	{ID: "e", Title: "Dreams", Artist: "Fleetwood Mac", Album: "Rumours", Genre: "Rock", TrackNumber: 2, Year: 1977, DurationMs: 257000},
+	{ID: "f", Title: "Go Your Own Way", Artist: "Fleetwood Mac", Album: "Rumours", Genre: "Rock", TrackNumber: 5, Year: 1977, DurationMs: 210000},
+	{ID: "g", Title: "Everywhere", Artist: "Fleetwood Mac", Album: "Tango in the Night", TrackNumber: 1, Year: 1987, DurationMs: 210000},
+}
+
+func testSearch() *Service {
+	return &Service{tracks: newTrackIndex(searchTracks)}
+}
+
+// searchIDs runs q and returns the IDs found, in order.
+func searchIDs(t *testing.T, s *Service, q SearchQuery) []string {
+	t.Helper()
+	result, err := s.Search(q)
+	if err != nil {
+		t.Fatalf("Search(%+v): %v", q, err)
+	}
+	var ids []string
+	for _, track := range result.Tracks {
+		ids = append(ids, track.ID)
+	}
+	return ids
+}
+
+func TestSearchText(t *testing.T) {
+	s := testSearch()
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"california", []string{"a", "b", "d"}},
+		{"calif", []string{"a", "b", "d"}},
+		{"CALIF", []string{"a", "b", "d"}},
+		{"dream", []string{"d", "e"}},
+		{"california dream", []string{"d"}},
+		{"  hotel,   eagles ", []string{"a", "b"}},
+		{"eagles easy", []string{"c"}},
+		{"fleetwood rock", []string{"e", "f"}},
+		{"fornia", nil},
+		{"california rumours", nil},
+		{"", []string{"a", "b", "c", "d", "e", "f", "g"}},
+	}
+	for _, tt := range tests {
+		got := searchIDs(t, s, SearchQuery{Text: tt.text, Sort: "title"})
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSearchSort(t *testing.T) {
+	s := testSearch()
+
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"title", []string{"d", "e", "g", "f", "a", "b", "c"}},
+		{"-title", []string{"c", "b", "a", "f", "g", "e", "d"}},
+		// Then by album, disc and track
+		{"", []string{"c", "a", "b", "e", "f", "g", "d"}},
+		{"artist", []string{"c", "a", "b", "e", "f", "g", "d"}},
+		{"album", []string{"c", "a", "b", "d", "e", "f", "g"}},
+		// Ties fall back to the track ID
+		{"year", []string{"d", "c", "a", "b", "e", "f", "g"}},
+		{"-year", []string{"g", "f", "e", "b", "a", "c", "d"}},
+		{"duration", []string{"d", "c", "f", "g", "e", "b", "a"}},
+		{"-duration", []string{"a", "b", "e", "g", "f", "c", "d"}},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 5; i++ {
+			if got := searchIDs(t, s, SearchQuery{Sort: tt.sort}); !slices.Equal(got, tt.want) {
+				t.Fatalf("sorted by %q = %v, want %v", tt.sort, got, tt.want)
+			}
+		}
+	}
+
+	if _, err := s.Search(SearchQuery{Sort: "rating"}); err == nil {
+		t.Fatal("sorted by an unknown key")
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	s := testSearch()
+
+	for _, sort := range []string{"year", "-duration"} {
+		want := searchIDs(t, s, SearchQuery{Sort: sort})
+
+		var got []string
+		q := SearchQuery{Sort: sort, Limit: 3}
+		for pages := 0; ; pages++ {
+			if pages == 3 {
+				t.Fatalf("still paging after %v", got)
+			}
+			result, err := s.Search(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Total != len(searchTracks) {
+				t.Fatalf("total = %d, want %d", result.Total, len(searchTracks))
+			}
+			for _, track := range result.Tracks {
+				got = append(got, track.ID)
+			}
+			if result.NextCursor == "" {
+				break
+			}
+			q.Cursor = result.NextCursor
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("pages sorted by %q = %v, want %v", sort, got, want)
+		}
+	}
+
+	// A cursor past the end is an empty page
+	result, err := s.Search(SearchQuery{Cursor: encodeCursor(100)})
+	if err != nil || len(result.Tracks) != 0 || result.NextCursor != "" {
+		t.Fatalf("Search past the end = %+v, %v, want an empty page", result, err)
+	}
+}
+
+func TestSearchBadCursor(t *testing.T) {
+	s := testSearch()
+
+	for _, cursor := range []string{
+		"garbage!",
+		"%%%",
+		base64.RawURLEncoding.EncodeToString([]byte("ten")),
+		base64.RawURLEncoding.EncodeToString([]byte("-3")),
+		base64.RawURLEncoding.EncodeToString([]byte("99999999999999999999")),
+	} {
+		if result, err := s.Search(SearchQuery{Cursor: cursor}); err == nil {
+			t.Errorf("Search with cursor %q = %d tracks, want an error", cursor, len(result.Tracks))
+		}
+	}
+}
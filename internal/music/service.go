@@ -5,50 +5,141 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
 type Track struct {
-	ID       string `json:"id"`
-	Title    string `json:"title"`
-	Artist   string `json:"artist"`
-	Album    string `json:"album"`
-	Duration int    `json:"duration"` // in seconds
-	IsVideo  bool   `json:"is_video"` // true if this is a video file
-	Path     string `json:"-"`        // don't expose file path
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Artist      string `json:"artist"`
+	Album       string `json:"album"`
+	AlbumArtist string `json:"album_artist,omitempty"`
+	TrackNumber int    `json:"track_number,omitempty"`
+	DiscNumber  int    `json:"disc_number,omitempty"`
+	Year        int    `json:"year,omitempty"`
+	Genre       string `json:"genre,omitempty"`
+	Duration    int    `json:"duration"`    // in seconds
+	DurationMs  int64  `json:"duration_ms"` // in milliseconds
+	IsVideo     bool   `json:"is_video"`    // true if this is a video file
+	ArtURL      string `json:"art_url,omitempty"`
+	Path        string `json:"-"` // don't expose file path
+	ArtPath     string `json:"-"` // file holding the cover art, if any
 }
 
 type Service struct {
 	musicDir string
 	catalog  []Track
+	tracks   *trackIndex
+	library  *library
+	mu       sync.RWMutex
+
+	indexPath string
+	index     *catalogIndex
+	saveMu    sync.Mutex
+
+	artCacheDir string
+
+	watcher   *fsnotify.Watcher
+	listeners []func(CatalogChange)
 }
 
-func NewService(musicDir string) *Service {
+// NewService scans musicDir and builds the catalog. When indexPath is not
+// empty, parsed metadata is cached there so that unchanged files don't need
+// to be read again on the next start, and resized cover art is cached in an
+// "art" directory alongside it.
+func NewService(musicDir, indexPath string) *Service {
 	s := &Service{
-		musicDir: musicDir,
-		catalog:  make([]Track, 0),
+		musicDir:  filepath.Clean(musicDir),
+		catalog:   make([]Track, 0),
+		tracks:    newTrackIndex(nil),
+		library:   newLibrary(nil),
+		indexPath: indexPath,
+		index:     newCatalogIndex(),
 	}
-	s.scanMusicDirectory()
+
+	if indexPath != "" {
+		s.artCacheDir = filepath.Join(filepath.Dir(indexPath), "art")
+
+		idx, err := loadCatalogIndex(indexPath)
+		switch {
+		case err == nil:
+			s.index = idx
+		case !os.IsNotExist(err):
+			log.Printf("Discarding catalog index: %v", err)
+		}
+	}
+
+	s.commitScan(s.scanMusicDirectory())
 	return s
 }
 
 func (s *Service) GetCatalog() []Track {
-	return s.catalog
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	catalog := make([]Track, len(s.catalog))
+	copy(catalog, s.catalog)
+	return catalog
 }
 
 func (s *Service) GetTrack(id string) (*Track, error) {
-	for _, track := range s.catalog {
-		if track.ID == id {
-			return &track, nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	track, exists := s.tracks.byID[id]
+	if !exists {
+		// Links made before IDs were content-based still carry the path
+		if target, ok := s.index.Redirects[id]; ok {
+			track, exists = s.tracks.byID[target]
 		}
 	}
-	return nil, fmt.Errorf("track not found")
+	if !exists {
+		return nil, fmt.Errorf("track not found")
+	}
+	return &track, nil
+}
+
+// pathID returns the relative, slash-separated path that used to serve as
+// the ID of the track at path.
+func (s *Service) pathID(path string) string {
+	relPath, _ := filepath.Rel(s.musicDir, path)
+	return filepath.ToSlash(relPath)
+}
+
+// isMediaFile reports whether path has one of the supported audio or video
+// extensions
+func isMediaFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".mp3" || ext == ".wav" || ext == ".flac" || ext == ".ogg" || ext == ".m4a" ||
+		ext == ".mp4" || ext == ".mkv" || ext == ".avi" || ext == ".mov" || ext == ".webm" || ext == ".wmv"
 }
 
-func (s *Service) scanMusicDirectory() {
+func (s *Service) scanMusicDirectory() ([]Track, map[string]indexEntry) {
 	log.Printf("Scanning music directory: %s", s.musicDir)
-	
+
+	catalog := make([]Track, 0)
+	entries := make(map[string]indexEntry)
+	seen := make(map[string]bool)
+	cached := 0
+
+	// Sidecar cover images may have changed without the tracks changing,
+	// so look them up again, once per directory
+	sidecars := make(map[string]string)
+	findSidecar := func(dir string) (string, bool) {
+		path, ok := sidecars[dir]
+		if !ok {
+			path, _ = findSidecarArt(dir)
+			sidecars[dir] = path
+		}
+		return path, path != ""
+	}
+
 	err := filepath.WalkDir(s.musicDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -59,12 +150,30 @@ func (s *Service) scanMusicDirectory() {
 		}
 
 		// Check for audio and video file extensions
-		ext := strings.ToLower(filepath.Ext(path))
-		if ext == ".mp3" || ext == ".wav" || ext == ".flac" || ext == ".ogg" || ext == ".m4a" ||
-		   ext == ".mp4" || ext == ".mkv" || ext == ".avi" || ext == ".mov" || ext == ".webm" || ext == ".wmv" {
-			track := s.createTrackFromPath(path)
-			s.catalog = append(s.catalog, track)
+		if !isMediaFile(path) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			log.Printf("Error reading %s: %v", path, err)
+			return nil
+		}
+
+		track, fromIndex := s.trackForFile(path, info)
+		if fromIndex {
+			cached++
+			if !track.hasEmbeddedArt() {
+				track.setArt(false, findSidecar)
+			}
+		}
+		// Identical copies of a file share a fingerprint
+		if seen[track.ID] {
+			track.setID(duplicateID(track.ID, s.pathID(path)))
 		}
+		seen[track.ID] = true
+		catalog = append(catalog, track)
+		entries[path] = newIndexEntry(track, info)
 
 		return nil
 	})
@@ -73,7 +182,84 @@ func (s *Service) scanMusicDirectory() {
 		log.Printf("Error scanning music directory: %v", err)
 	}
 
-	log.Printf("Found %d tracks", len(s.catalog))
+	log.Printf("Found %d tracks (%d unchanged since last scan)", len(catalog), cached)
+	return catalog, entries
+}
+
+// trackForFile returns the indexed track for path if the file is
+// unchanged, and parses it otherwise.
+func (s *Service) trackForFile(path string, info fs.FileInfo) (Track, bool) {
+	s.mu.RLock()
+	track, ok := s.index.lookup(path, info)
+	s.mu.RUnlock()
+
+	if ok {
+		return track, true
+	}
+	return s.createTrackFromPath(path), false
+}
+
+// commitScan replaces the catalog and index with the results of a full
+// scan and returns what changed.
+func (s *Service) commitScan(catalog []Track, entries map[string]indexEntry) CatalogChange {
+	s.mu.Lock()
+	change := diffCatalogs(s.catalog, catalog)
+	moved := countMoves(s.tracks.byID, change.Updated)
+	s.catalog = catalog
+	s.tracks = newTrackIndex(catalog)
+	s.library = newLibrary(s.tracks.byID)
+	s.index.Entries = entries
+
+	// Old path IDs keep resolving for as long as their track exists
+	for path, target := range s.index.Redirects {
+		if _, ok := s.tracks.byID[target]; !ok {
+			delete(s.index.Redirects, path)
+		}
+	}
+	for _, track := range catalog {
+		s.index.Redirects[s.pathID(track.Path)] = track.ID
+	}
+
+	s.index.Generation++
+	s.index.LastScan = time.Now()
+	s.mu.Unlock()
+
+	if moved > 0 {
+		log.Printf("Detected %d moved or renamed tracks", moved)
+	}
+	s.saveIndex()
+	return change
+}
+
+func (s *Service) saveIndex() {
+	if s.indexPath == "" {
+		return
+	}
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.RLock()
+	err := s.index.save(s.indexPath)
+	s.mu.RUnlock()
+
+	if err != nil {
+		log.Printf("Error saving catalog index: %v", err)
+	}
+}
+
+// Status reports the size of the catalog and the state of its index.
+func (s *Service) Status() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return Status{
+		Tracks:     len(s.catalog),
+		Generation: s.index.Generation,
+		LastScan:   s.index.LastScan,
+		Indexed:    s.indexPath != "",
+		Watching:   s.watcher != nil,
+	}
 }
 
 func (s *Service) createTrackFromPath(path string) Track {
@@ -85,39 +271,71 @@ func (s *Service) createTrackFromPath(path string) Track {
 	ext := strings.ToLower(filepath.Ext(path))
 	isVideo := ext == ".mp4" || ext == ".mkv" || ext == ".avi" || ext == ".mov" || ext == ".webm" || ext == ".wmv"
 	
-	// Simple parsing - try to extract artist and title from filename
-	// Format: "Artist - Title" or just "Title"
-	parts := strings.Split(name, " - ")
-	
-	var artist, title string
-	if len(parts) >= 2 {
-		artist = strings.TrimSpace(parts[0])
-		title = strings.TrimSpace(strings.Join(parts[1:], " - "))
-	} else {
-		artist = "Unknown Artist"
-		title = name
+	// Prefer embedded tags, falling back to the filename for anything missing
+	tags, err := ReadTags(path)
+	if err != nil {
+		tags = &Tags{}
 	}
 
-	// Use relative path from music directory as ID
-	relPath, _ := filepath.Rel(s.musicDir, path)
-	id := strings.ReplaceAll(relPath, "\\", "/") // normalize path separators
+	if tags.Title == "" || tags.Artist == "" {
+		// Simple parsing - try to extract artist and title from filename
+		// Format: "Artist - Title" or just "Title"
+		parts := strings.Split(name, " - ")
+
+		var artist, title string
+		if len(parts) >= 2 {
+			artist = strings.TrimSpace(parts[0])
+			title = strings.TrimSpace(strings.Join(parts[1:], " - "))
+		} else {
+			artist = unknownArtist
+			title = name
+		}
+		tags.merge(&Tags{Title: title, Artist: artist})
+	}
+	if tags.Album == "" {
+		tags.Album = unknownAlbum
+	}
 
-	return Track{
-		ID:       id,
-		Title:    title,
-		Artist:   artist,
-		Album:    "Unknown Album",
-		Duration: 0, // TODO: extract actual duration from file metadata
-		IsVideo:  isVideo,
-		Path:     path,
+	duration, err := ReadDuration(path)
+	if err != nil {
+		duration = 0
+	}
+
+	// Derive the ID from the audio itself so it survives renames, moves
+	// and retagging
+	id, err := Fingerprint(path)
+	if err != nil {
+		id = pathFingerprint(s.pathID(path))
 	}
+
+	track := Track{
+		ID:          id,
+		Title:       tags.Title,
+		Artist:      tags.Artist,
+		Album:       tags.Album,
+		AlbumArtist: tags.AlbumArtist,
+		TrackNumber: tags.TrackNumber,
+		DiscNumber:  tags.DiscNumber,
+		Year:        tags.Year,
+		Genre:       tags.Genre,
+		Duration:    int(duration.Round(time.Second) / time.Second),
+		DurationMs:  duration.Milliseconds(),
+		IsVideo:     isVideo,
+		Path:        path,
+	}
+	track.setArt(tags.Picture != nil, findSidecarArt)
+
+	return track
 }
 
 func (s *Service) RescanCatalog() {
-	s.catalog = make([]Track, 0)
-	s.scanMusicDirectory()
+	change := s.commitScan(s.scanMusicDirectory())
+	s.notify(change)
 }
 
 func (s *Service) GetCatalogJSON() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	return json.Marshal(s.catalog)
 }
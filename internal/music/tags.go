@@ -0,0 +1,932 @@
+package music
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Tags holds the metadata read from a file's embedded tags.
+type Tags struct {
+	Title       string
+	Artist      string
+	Album       string
+	AlbumArtist string
+	Genre       string
+	TrackNumber int
+	DiscNumber  int
+	Year        int
+	Picture     *Picture // embedded cover art, if any
+}
+
+func (t *Tags) empty() bool {
+	return t.Title == "" && t.Artist == "" && t.Album == "" && t.Picture == nil
+}
+
+// merge fills any fields left blank in t from other
+func (t *Tags) merge(other *Tags) {
+	if other == nil {
+		return
+	}
+	if t.Title == "" {
+		t.Title = other.Title
+	}
+	if t.Artist == "" {
+		t.Artist = other.Artist
+	}
+	if t.Album == "" {
+		t.Album = other.Album
+	}
+	if t.AlbumArtist == "" {
+		t.AlbumArtist = other.AlbumArtist
+	}
+	if t.Genre == "" {
+		t.Genre = other.Genre
+	}
+	if t.TrackNumber == 0 {
+		t.TrackNumber = other.TrackNumber
+	}
+	if t.DiscNumber == 0 {
+		t.DiscNumber = other.DiscNumber
+	}
+	if t.Year == 0 {
+		t.Year = other.Year
+	}
+	t.addPicture(other.Picture)
+}
+
+// ReadTags reads embedded metadata from the file at path. It understands
+// ID3v2/ID3v1 (MP3), Vorbis comments (FLAC, OGG) and iTunes-style atoms
+// (M4A, MP4, MOV).
+func ReadTags(path string) (*Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		return readMP3Tags(f)
+	case ".flac":
+		return readFLACTags(f)
+	case ".ogg":
+		return readOggTags(f)
+	case ".m4a", ".mp4", ".mov":
+		return readMP4Tags(f)
+	}
+	return nil, fmt.Errorf("unsupported tag format")
+}
+
+func readMP3Tags(f *os.File) (*Tags, error) {
+	tags, _, err := readID3v2(f)
+	if err != nil {
+		tags = &Tags{}
+	}
+	tags.merge(readID3v1(f))
+
+	if tags.empty() {
+		return nil, fmt.Errorf("no tags found")
+	}
+	return tags, nil
+}
+
+// ID3v2
+
+const id3HeaderSize = 10
+
+// readID3v2 parses the ID3v2 tag at the start of r and returns the tags
+// together with the total size of the tag in bytes (header and footer
+// included).
+func readID3v2(r io.ReadSeeker) (*Tags, int64, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+
+	header := make([]byte, id3HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, err
+	}
+	if string(header[0:3]) != "ID3" {
+		return nil, 0, fmt.Errorf("no ID3v2 tag")
+	}
+
+	version := header[3]
+	flags := header[5]
+	size := int64(syncsafe(header[6:10]))
+	total := id3HeaderSize + size
+	if flags&0x10 != 0 {
+		total += id3HeaderSize // footer present
+	}
+
+	if version < 2 || version > 4 {
+		return nil, total, fmt.Errorf("unsupported ID3v2 version 2.%d", version)
+	}
+
+	// Check the size against the file before trusting it with an allocation
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, total, err
+	}
+	if size > end-id3HeaderSize {
+		return nil, total, fmt.Errorf("ID3v2 tag larger than the file")
+	}
+	if _, err := r.Seek(id3HeaderSize, io.SeekStart); err != nil {
+		return nil, total, err
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, total, err
+	}
+
+	// In v2.2 and v2.3 unsynchronisation applies to the whole tag
+	if flags&0x80 != 0 && version < 4 {
+		body = removeUnsync(body)
+	}
+
+	// Skip the extended header
+	if flags&0x40 != 0 && version > 2 && len(body) >= 4 {
+		var extSize int
+		if version == 3 {
+			extSize = int(binary.BigEndian.Uint32(body[0:4])) + 4
+		} else {
+			extSize = int(syncsafe(body[0:4]))
+		}
+		if extSize > len(body) {
+			return nil, total, fmt.Errorf("invalid extended header")
+		}
+		body = body[extSize:]
+	}
+
+	tags := &Tags{}
+	for _, frame := range id3Frames(body, version) {
+		applyID3Frame(tags, frame.id, frame.data)
+	}
+	return tags, total, nil
+}
+
+type id3Frame struct {
+	id   string
+	data []byte
+}
+
+func id3Frames(body []byte, version byte) []id3Frame {
+	var frames []id3Frame
+
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	for len(body) >= headerLen {
+		// Padding
+		if body[0] == 0 {
+			break
+		}
+
+		id := string(body[0:idLen])
+		var size int
+		var flags uint16
+		switch version {
+		case 2:
+			size = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(body[4:8]))
+			flags = binary.BigEndian.Uint16(body[8:10])
+		case 4:
+			size = int(syncsafe(body[4:8]))
+			flags = binary.BigEndian.Uint16(body[8:10])
+		}
+
+		if size < 0 || headerLen+size > len(body) {
+			break
+		}
+		data := body[headerLen : headerLen+size]
+		body = body[headerLen+size:]
+
+		if version == 3 && flags&0x00C0 != 0 {
+			// Compressed or encrypted frames are not supported
+			continue
+		}
+		if version == 4 {
+			if flags&0x000C != 0 {
+				continue
+			}
+			if flags&0x0001 != 0 && len(data) >= 4 {
+				data = data[4:] // data length indicator
+			}
+			if flags&0x0002 != 0 {
+				data = removeUnsync(data)
+			}
+		}
+
+		frames = append(frames, id3Frame{id: id, data: data})
+	}
+
+	return frames
+}
+
+func applyID3Frame(tags *Tags, id string, data []byte) {
+	switch id {
+	case "TIT2", "TT2":
+		tags.Title = id3Text(data)
+	case "TPE1", "TP1":
+		tags.Artist = id3Text(data)
+	case "TALB", "TAL":
+		tags.Album = id3Text(data)
+	case "TPE2", "TP2":
+		tags.AlbumArtist = id3Text(data)
+	case "TCON", "TCO":
+		tags.Genre = id3Genre(id3Text(data))
+	case "TRCK", "TRK":
+		tags.TrackNumber = leadingInt(id3Text(data))
+	case "TPOS", "TPA":
+		tags.DiscNumber = leadingInt(id3Text(data))
+	case "TYER", "TYE", "TDRC", "TDOR":
+		if tags.Year == 0 {
+			tags.Year = leadingInt(id3Text(data))
+		}
+	case "APIC", "PIC":
+		tags.addPicture(parseID3Picture(data, id == "PIC"))
+	}
+}
+
+// parseID3Picture decodes an APIC frame, or a PIC frame from ID3v2.2.
+func parseID3Picture(data []byte, v22 bool) *Picture {
+	if len(data) < 2 {
+		return nil
+	}
+	encoding := data[0]
+	rest := data[1:]
+
+	// MIME type (APIC) or three letter image format (PIC)
+	if v22 {
+		if len(rest) < 3 {
+			return nil
+		}
+		rest = rest[3:]
+	} else {
+		end := bytes.IndexByte(rest, 0)
+		if end < 0 {
+			return nil
+		}
+		rest = rest[end+1:]
+	}
+
+	if len(rest) < 1 {
+		return nil
+	}
+	kind := rest[0]
+	rest = rest[1:]
+
+	// Skip the description, whose terminator depends on the text encoding
+	if encoding == 1 || encoding == 2 {
+		end := -1
+		for i := 0; i+1 < len(rest); i += 2 {
+			if rest[i] == 0 && rest[i+1] == 0 {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return nil
+		}
+		rest = rest[end+2:]
+	} else {
+		end := bytes.IndexByte(rest, 0)
+		if end < 0 {
+			return nil
+		}
+		rest = rest[end+1:]
+	}
+
+	return newPicture(rest, kind)
+}
+
+// id3Text decodes a text information frame. Only the first value of a
+// multi-value (v2.4) frame is returned.
+func id3Text(data []byte) string {
+	if len(data) < 1 {
+		return ""
+	}
+	text := decodeID3String(data[0], data[1:])
+	if i := strings.IndexByte(text, 0); i >= 0 {
+		text = text[:i]
+	}
+	return strings.TrimSpace(text)
+}
+
+func decodeID3String(encoding byte, b []byte) string {
+	switch encoding {
+	case 0:
+		return latin1(b)
+	case 1:
+		// UTF-16 with BOM
+		if len(b) >= 2 {
+			if b[0] == 0xFF && b[1] == 0xFE {
+				return decodeUTF16(b[2:], binary.LittleEndian)
+			}
+			if b[0] == 0xFE && b[1] == 0xFF {
+				return decodeUTF16(b[2:], binary.BigEndian)
+			}
+		}
+		return decodeUTF16(b, binary.LittleEndian)
+	case 2:
+		return decodeUTF16(b, binary.BigEndian)
+	default:
+		return string(b)
+	}
+}
+
+func decodeUTF16(b []byte, order binary.ByteOrder) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, order.Uint16(b[i:]))
+	}
+	return string(utf16.Decode(units))
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// id3Genre resolves numeric genre references such as "(17)" or "17" to
+// their ID3v1 names.
+func id3Genre(genre string) string {
+	if strings.HasPrefix(genre, "(") {
+		if end := strings.IndexByte(genre, ')'); end > 0 {
+			if n, err := strconv.Atoi(genre[1:end]); err == nil {
+				if rest := strings.TrimSpace(genre[end+1:]); rest != "" {
+					return rest
+				}
+				return id3v1GenreName(n)
+			}
+		}
+	}
+	if n, err := strconv.Atoi(genre); err == nil {
+		return id3v1GenreName(n)
+	}
+	return genre
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+func removeUnsync(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xFF && i+1 < len(b) && b[i+1] == 0x00 {
+			i++
+		}
+	}
+	return out
+}
+
+// ID3v1
+
+const id3v1Size = 128
+
+func readID3v1(r io.ReadSeeker) *Tags {
+	if _, err := r.Seek(-id3v1Size, io.SeekEnd); err != nil {
+		return nil
+	}
+	b := make([]byte, id3v1Size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil
+	}
+	if string(b[0:3]) != "TAG" {
+		return nil
+	}
+
+	field := func(f []byte) string {
+		if i := bytes.IndexByte(f, 0); i >= 0 {
+			f = f[:i]
+		}
+		return strings.TrimSpace(latin1(f))
+	}
+
+	tags := &Tags{
+		Title:  field(b[3:33]),
+		Artist: field(b[33:63]),
+		Album:  field(b[63:93]),
+		Year:   leadingInt(field(b[93:97])),
+	}
+	// ID3v1.1 stores the track number in the last byte of the comment
+	if b[125] == 0 && b[126] != 0 {
+		tags.TrackNumber = int(b[126])
+	}
+	tags.Genre = id3v1GenreName(int(b[127]))
+	return tags
+}
+
+// Vorbis comments
+
+func readFLACTags(f *os.File) (*Tags, error) {
+	tags := &Tags{}
+	err := walkFLACMetadata(f, func(blockType byte, data []byte) bool {
+		switch blockType {
+		case flacVorbisComment:
+			tags.merge(parseVorbisComment(data))
+		case flacPicture:
+			tags.addPicture(parseFLACPicture(data))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tags.empty() {
+		return nil, fmt.Errorf("no tags found")
+	}
+	return tags, nil
+}
+
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+// walkFLACMetadata calls fn for every metadata block of a FLAC stream
+// until fn returns false or the last block has been read.
+func walkFLACMetadata(r io.ReadSeeker, fn func(blockType byte, data []byte) bool) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// FLAC files may carry a leading ID3v2 tag
+	_, skip, _ := readID3v2(r)
+	if _, err := r.Seek(skip, io.SeekStart); err != nil {
+		return err
+	}
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return err
+	}
+	if string(magic) != "fLaC" {
+		return fmt.Errorf("not a FLAC stream")
+	}
+
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return err
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+
+		data, err := readFull(r, int64(length))
+		if err != nil {
+			return err
+		}
+		if !fn(blockType, data) || last {
+			return nil
+		}
+	}
+}
+
+func parseVorbisComment(data []byte) *Tags {
+	tags := &Tags{}
+	if len(data) < 4 {
+		return tags
+	}
+
+	vendorLen := int(binary.LittleEndian.Uint32(data[0:4]))
+	pos := 4 + vendorLen
+	if vendorLen < 0 || pos+4 > len(data) {
+		return tags
+	}
+	count := int(binary.LittleEndian.Uint32(data[pos:]))
+	pos += 4
+
+	for i := 0; i < count && pos+4 <= len(data); i++ {
+		length := int(binary.LittleEndian.Uint32(data[pos:]))
+		pos += 4
+		if length < 0 || pos+length > len(data) {
+			break
+		}
+		comment := string(data[pos : pos+length])
+		pos += length
+
+		key, value, ok := strings.Cut(comment, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			setOnce(&tags.Title, value)
+		case "ARTIST":
+			setOnce(&tags.Artist, value)
+		case "ALBUM":
+			setOnce(&tags.Album, value)
+		case "ALBUMARTIST", "ALBUM ARTIST", "ALBUM_ARTIST":
+			setOnce(&tags.AlbumArtist, value)
+		case "GENRE":
+			setOnce(&tags.Genre, value)
+		case "TRACKNUMBER":
+			if tags.TrackNumber == 0 {
+				tags.TrackNumber = leadingInt(value)
+			}
+		case "DISCNUMBER":
+			if tags.DiscNumber == 0 {
+				tags.DiscNumber = leadingInt(value)
+			}
+		case "DATE", "YEAR", "ORIGINALDATE":
+			if tags.Year == 0 {
+				tags.Year = leadingInt(value)
+			}
+		case "METADATA_BLOCK_PICTURE":
+			// A base64 encoded FLAC picture block
+			if block, err := base64.StdEncoding.DecodeString(value); err == nil {
+				tags.addPicture(parseFLACPicture(block))
+			}
+		}
+	}
+
+	return tags
+}
+
+func readOggTags(f *os.File) (*Tags, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	packets := newOggPacketReader(f)
+	for i := 0; i < 2; i++ {
+		packet, err := packets.next()
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			continue // identification header
+		}
+
+		var tags *Tags
+		switch {
+		case bytes.HasPrefix(packet, []byte("\x03vorbis")):
+			tags = parseVorbisComment(packet[7:])
+		case bytes.HasPrefix(packet, []byte("OpusTags")):
+			tags = parseVorbisComment(packet[8:])
+		case len(packet) > 4 && packet[0]&0x7F == flacVorbisComment:
+			// Ogg FLAC: the second packet is a bare metadata block
+			tags = parseVorbisComment(packet[4:])
+		}
+		if tags != nil && !tags.empty() {
+			return tags, nil
+		}
+	}
+	return nil, fmt.Errorf("no tags found")
+}
+
+// oggPacketReader reassembles the packets of the first logical bitstream
+// in an Ogg container.
+type oggPacketReader struct {
+	r       io.Reader
+	serial  uint32
+	started bool
+	pending [][]byte
+	partial []byte
+}
+
+func newOggPacketReader(r io.Reader) *oggPacketReader {
+	return &oggPacketReader{r: r}
+}
+
+type oggPageHeader struct {
+	headerType byte
+	granule    int64
+	serial     uint32
+	segments   []byte
+}
+
+const oggPageHeaderSize = 27
+
+func readOggPageHeader(r io.Reader) (*oggPageHeader, error) {
+	b := make([]byte, oggPageHeaderSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	if string(b[0:4]) != "OggS" {
+		return nil, fmt.Errorf("invalid ogg page")
+	}
+	segments := make([]byte, b[26])
+	if _, err := io.ReadFull(r, segments); err != nil {
+		return nil, err
+	}
+	return &oggPageHeader{
+		headerType: b[5],
+		granule:    int64(binary.LittleEndian.Uint64(b[6:14])),
+		serial:     binary.LittleEndian.Uint32(b[14:18]),
+		segments:   segments,
+	}, nil
+}
+
+func (p *oggPacketReader) next() ([]byte, error) {
+	for len(p.pending) == 0 {
+		header, err := readOggPageHeader(p.r)
+		if err != nil {
+			return nil, err
+		}
+
+		var bodyLen int
+		for _, s := range header.segments {
+			bodyLen += int(s)
+		}
+		body := make([]byte, bodyLen)
+		if _, err := io.ReadFull(p.r, body); err != nil {
+			return nil, err
+		}
+
+		if !p.started {
+			p.serial = header.serial
+			p.started = true
+		}
+		if header.serial != p.serial {
+			continue
+		}
+
+		pos := 0
+		for _, s := range header.segments {
+			p.partial = append(p.partial, body[pos:pos+int(s)]...)
+			pos += int(s)
+			if s < 255 {
+				p.pending = append(p.pending, p.partial)
+				p.partial = nil
+			}
+		}
+	}
+
+	packet := p.pending[0]
+	p.pending = p.pending[1:]
+	return packet, nil
+}
+
+// MP4 atoms
+
+// mp4Atom is a box and its payload.
+type mp4Atom struct {
+	kind string
+	data []byte
+}
+
+// maxMoovSize bounds how much of a file is buffered when looking for tags
+const maxMoovSize = 64 << 20
+
+// readMoov locates the top-level moov box and returns its payload.
+func readMoov(r io.ReadSeeker) ([]byte, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, fmt.Errorf("moov atom not found")
+		}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		kind := string(header[4:8])
+		headerLen := int64(8)
+
+		switch size {
+		case 0:
+			if kind != "moov" {
+				return nil, fmt.Errorf("moov atom not found")
+			}
+			data, err := io.ReadAll(io.LimitReader(r, maxMoovSize))
+			return data, err
+		case 1:
+			ext := make([]byte, 8)
+			if _, err := io.ReadFull(r, ext); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(ext))
+			headerLen = 16
+		}
+		if size < headerLen {
+			return nil, fmt.Errorf("invalid atom size")
+		}
+
+		if kind == "moov" {
+			if size-headerLen > maxMoovSize {
+				return nil, fmt.Errorf("moov atom too large")
+			}
+			return readFull(r, size-headerLen)
+		}
+
+		if _, err := r.Seek(size-headerLen, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// mp4Children splits an in-memory box payload into its child boxes.
+func mp4Children(data []byte) []mp4Atom {
+	var atoms []mp4Atom
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data[0:4]))
+		kind := string(data[4:8])
+		headerLen := 8
+
+		switch size {
+		case 0:
+			size = len(data)
+		case 1:
+			if len(data) < 16 {
+				return atoms
+			}
+			size = int(binary.BigEndian.Uint64(data[8:16]))
+			headerLen = 16
+		}
+		if size < headerLen || size > len(data) {
+			break
+		}
+
+		atoms = append(atoms, mp4Atom{kind: kind, data: data[headerLen:size]})
+		data = data[size:]
+	}
+	return atoms
+}
+
+// mp4Find follows a path of box types starting at data and returns the
+// payload of the last one.
+func mp4Find(data []byte, path ...string) ([]byte, bool) {
+	for _, kind := range path {
+		found := false
+		for _, atom := range mp4Children(data) {
+			if atom.kind == kind {
+				data = atom.data
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+		// meta is a full box with four bytes of version and flags
+		if kind == "meta" && len(data) >= 4 {
+			data = data[4:]
+		}
+	}
+	return data, true
+}
+
+func mp4ItemList(moov []byte) ([]mp4Atom, bool) {
+	ilst, ok := mp4Find(moov, "udta", "meta", "ilst")
+	if !ok {
+		ilst, ok = mp4Find(moov, "meta", "ilst")
+	}
+	if !ok {
+		return nil, false
+	}
+	return mp4Children(ilst), true
+}
+
+// mp4ItemData returns the value stored in an ilst item's data box.
+func mp4ItemData(item []byte) []byte {
+	for _, atom := range mp4Children(item) {
+		if atom.kind == "data" && len(atom.data) >= 8 {
+			// type indicator (4 bytes) and locale (4 bytes)
+			return atom.data[8:]
+		}
+	}
+	return nil
+}
+
+func readMP4Tags(f *os.File) (*Tags, error) {
+	moov, err := readMoov(f)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := mp4ItemList(moov)
+	if !ok {
+		return nil, fmt.Errorf("no tags found")
+	}
+
+	tags := &Tags{}
+	for _, item := range items {
+		value := mp4ItemData(item.data)
+		if value == nil {
+			continue
+		}
+		text := strings.TrimSpace(string(value))
+
+		switch item.kind {
+		case "\xa9nam":
+			tags.Title = text
+		case "\xa9ART":
+			tags.Artist = text
+		case "\xa9alb":
+			tags.Album = text
+		case "aART":
+			tags.AlbumArtist = text
+		case "\xa9gen":
+			tags.Genre = text
+		case "gnre":
+			if len(value) >= 2 && tags.Genre == "" {
+				tags.Genre = id3v1GenreName(int(binary.BigEndian.Uint16(value)) - 1)
+			}
+		case "\xa9day":
+			tags.Year = leadingInt(text)
+		case "trkn":
+			if len(value) >= 4 {
+				tags.TrackNumber = int(binary.BigEndian.Uint16(value[2:4]))
+			}
+		case "disk":
+			if len(value) >= 4 {
+				tags.DiscNumber = int(binary.BigEndian.Uint16(value[2:4]))
+			}
+		case "covr":
+			tags.addPicture(newPicture(value, pictureFrontCover))
+		}
+	}
+
+	if tags.empty() {
+		return nil, fmt.Errorf("no tags found")
+	}
+	return tags, nil
+}
+
+// Helpers
+
+// readFull reads exactly n bytes from r. Unlike io.ReadFull into a buffer
+// of size n, it only allocates as much as r really holds, so a size field
+// in a truncated or hostile file can't make it allocate more.
+func readFull(r io.Reader, n int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, n))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+func setOnce(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+// leadingInt parses the integer at the start of s, so "3/12" yields 3 and
+// "2001-05-01" yields 2001.
+func leadingInt(s string) int {
+	s = strings.TrimSpace(s)
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
+
+func id3v1GenreName(n int) string {
+	if n < 0 || n >= len(id3v1Genres) {
+		return ""
+	}
+	return id3v1Genres[n]
+}
+
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge",
+	"Hip-Hop", "Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B",
+	"Rap", "Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska",
+	"Death Metal", "Pranks", "Soundtrack", "Euro-Techno", "Ambient",
+	"Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance", "Classical",
+	"Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative",
+	"Instrumental Pop", "Instrumental Rock", "Ethnic", "Gothic", "Darkwave",
+	"Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap",
+	"Pop/Funk", "Jungle", "Native American", "Cabaret", "New Wave",
+	"Psychadelic", "Rave", "Showtunes", "Trailer", "Lo-Fi", "Tribal",
+	"Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll",
+	"Hard Rock", "Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion",
+	"Bebob", "Latin", "Revival", "Celtic", "Bluegrass", "Avantgarde",
+	"Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock",
+	"Slow Rock", "Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour",
+	"Speech", "Chanson", "Opera", "Chamber Music", "Sonata", "Symphony",
+	"Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam", "Club",
+	"Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul",
+	"Freestyle", "Duet", "Punk Rock", "Drum Solo", "A capella", "Euro-House",
+	"Dance Hall", "Goa", "Drum & Bass", "Club-House", "Hardcore", "Terror",
+	"Indie", "BritPop",
+}
@@ -0,0 +1,457 @@
+package music
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+// pngData is just enough of a PNG for its type to be detected.
+var pngData = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+
+// maxParseAlloc is how much parsing any one small hostile file may
+// allocate, whatever its size fields claim.
+const maxParseAlloc = 1 << 20
+
+func TestReadTagsID3v2(t *testing.T) {
+	for _, version := range []byte{2, 3, 4} {
+		t.Run("2."+string('0'+version), func(t *testing.T) {
+			ids := map[string]string{
+				"title": "TIT2", "artist": "TPE1", "album": "TALB", "album artist": "TPE2",
+				"genre": "TCON", "track": "TRCK", "disc": "TPOS", "year": "TYER", "picture": "APIC",
+			}
+			picture := []byte("\x00image/png\x00\x03cover\x00")
+			if version == 2 {
+				ids = map[string]string{
+					"title": "TT2", "artist": "TP1", "album": "TAL", "album artist": "TP2",
+					"genre": "TCO", "track": "TRK", "disc": "TPA", "year": "TYE", "picture": "PIC",
+				}
+				picture = []byte("\x00PNG\x03cover\x00")
+			} else if version == 4 {
+				ids["year"] = "TDRC"
+			}
+
+			tag := id3v2Tag(version,
+				id3v2Frame(version, ids["title"], id3v2Text("Title")),
+				id3v2Frame(version, ids["artist"], id3v2Text("Artist")),
+				id3v2Frame(version, ids["album"], id3v2Text("Album")),
+				id3v2Frame(version, ids["album artist"], id3v2Text("Album Artist")),
+				id3v2Frame(version, ids["genre"], id3v2Text("(17)")),
+				id3v2Frame(version, ids["track"], id3v2Text("3/12")),
+				id3v2Frame(version, ids["disc"], id3v2Text("2/2")),
+				id3v2Frame(version, ids["year"], id3v2Text("1999-05-01")),
+				id3v2Frame(version, ids["picture"], append(picture, pngData...)),
+			)
+			tags, err := ReadTags(writeFile(t, "a.mp3", append(tag, make([]byte, 256)...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Tags{
+				Title: "Title", Artist: "Artist", Album: "Album", AlbumArtist: "Album Artist",
+				Genre: "Rock", TrackNumber: 3, DiscNumber: 2, Year: 1999,
+			}
+			checkTags(t, tags, want, true)
+		})
+	}
+}
+
+func TestReadTagsID3v2UTF16(t *testing.T) {
+	text := []byte{1, 0xFF, 0xFE}
+	for _, unit := range utf16.Encode([]rune("Blåbær")) {
+		text = binary.LittleEndian.AppendUint16(text, unit)
+	}
+	tag := id3v2Tag(3, id3v2Frame(3, "TIT2", text))
+
+	tags, err := ReadTags(writeFile(t, "a.mp3", tag))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags.Title != "Blåbær" {
+		t.Fatalf("Title = %q, want %q", tags.Title, "Blåbær")
+	}
+}
+
+func TestReadTagsID3v1(t *testing.T) {
+	// ID3v1 fills in whatever ID3v2 left out
+	data := id3v2Tag(3, id3v2Frame(3, "TIT2", id3v2Text("Long Title")))
+	data = append(data, make([]byte, 256)...)
+	data = append(data, id3v1Tag("Short Title", "Artist", "Album", "1987", 7, 17)...)
+
+	tags, err := ReadTags(writeFile(t, "a.mp3", data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTags(t, tags, Tags{Title: "Long Title", Artist: "Artist", Album: "Album", Genre: "Rock", TrackNumber: 7, Year: 1987}, false)
+
+	tags, err = ReadTags(writeFile(t, "b.mp3", id3v1Tag("Short Title", "Artist", "Album", "1987", 0, 255)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTags(t, tags, Tags{Title: "Short Title", Artist: "Artist", Album: "Album", Year: 1987}, false)
+}
+
+func TestReadTagsFLAC(t *testing.T) {
+	data := flacStream(
+		flacBlock(flacStreamInfo, make([]byte, 34)),
+		flacBlock(flacVorbisComment, vorbisComment("test",
+			"TITLE=Title", "artist=Artist", "ALBUM=Album", "ALBUMARTIST=Album Artist",
+			"GENRE=Jazz", "TRACKNUMBER=4/10", "DISCNUMBER=1", "DATE=2004-01-01", "TITLE=Second Title")),
+		flacBlock(flacPicture, flacPictureData(pictureFrontCover, "image/png", pngData)),
+	)
+
+	tags, err := ReadTags(writeFile(t, "a.flac", data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTags(t, tags, Tags{
+		Title: "Title", Artist: "Artist", Album: "Album", AlbumArtist: "Album Artist",
+		Genre: "Jazz", TrackNumber: 4, DiscNumber: 1, Year: 2004,
+	}, true)
+}
+
+func TestReadTagsOgg(t *testing.T) {
+	comment := vorbisComment("test", "TITLE=Title", "ARTIST=Artist", "COMMENT="+strings.Repeat("long ", 60),
+		"METADATA_BLOCK_PICTURE="+base64.StdEncoding.EncodeToString(flacPictureData(pictureFrontCover, "image/png", pngData)))
+
+	tests := []struct {
+		name     string
+		id, tags []byte
+	}{
+		{"vorbis", []byte("\x01vorbis" + strings.Repeat("\x00", 23)), append([]byte("\x03vorbis"), comment...)},
+		{"opus", []byte("OpusHead" + strings.Repeat("\x00", 11)), append([]byte("OpusTags"), comment...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The comment packet spans a page boundary
+			data := oggPage(0x02, 0, tt.id)
+			data = append(data, oggPage(0, 0, tt.tags[:255])...)
+			data = append(data, oggPage(0x01, 0, tt.tags[255:])...)
+
+			tags, err := ReadTags(writeFile(t, "a.ogg", data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkTags(t, tags, Tags{Title: "Title", Artist: "Artist"}, true)
+		})
+	}
+}
+
+func TestReadTagsMP4(t *testing.T) {
+	ilst := mp4Box("ilst",
+		mp4Item("\xa9nam", []byte("Title")),
+		mp4Item("\xa9ART", []byte("Artist")),
+		mp4Item("\xa9alb", []byte("Album")),
+		mp4Item("aART", []byte("Album Artist")),
+		mp4Item("gnre", []byte{0, 18}),
+		mp4Item("\xa9day", []byte("2010-02-03")),
+		mp4Item("trkn", []byte{0, 0, 0, 5, 0, 12, 0, 0}),
+		mp4Item("disk", []byte{0, 0, 0, 2, 0, 2}),
+		mp4Item("covr", pngData),
+	)
+	want := Tags{
+		Title: "Title", Artist: "Artist", Album: "Album", AlbumArtist: "Album Artist",
+		Genre: "Rock", TrackNumber: 5, DiscNumber: 2, Year: 2010,
+	}
+
+	tests := []struct {
+		name string
+		moov []byte
+	}{
+		{"udta", mp4Box("moov", mp4Box("mvhd", make([]byte, 100)),
+			mp4Box("udta", mp4Box("meta", []byte{0, 0, 0, 0}, mp4Box("hdlr", make([]byte, 25)), ilst)))},
+		{"meta", mp4Box("moov", mp4Box("meta", []byte{0, 0, 0, 0}, ilst))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append(mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00")), mp4Box("mdat", make([]byte, 64))...)
+			data = append(data, tt.moov...)
+
+			tags, err := ReadTags(writeFile(t, "a.m4a", data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkTags(t, tags, want, true)
+		})
+	}
+}
+
+func TestReadTagsHostile(t *testing.T) {
+	streamInfo := flacBlock(flacStreamInfo, make([]byte, 34))
+
+	tests := []struct {
+		name  string
+		file  string
+		data  []byte
+		title string // what should still be read, if anything
+	}{
+		{
+			name:  "ID3v2 larger than the file",
+			file:  "a.mp3",
+			data:  append([]byte("ID3\x03\x00\x00\x7f\x7f\x7f\x7f"), id3v1Tag("Title", "", "", "", 0, 0)...),
+			title: "Title",
+		},
+		{
+			name: "ID3v2 frame larger than the tag",
+			file: "a.mp3",
+			data: id3v2Tag(3, []byte("TIT2\xff\xff\xff\xff\x00\x00\x00Title")),
+		},
+		{
+			name: "ID3v2 extended header larger than the tag",
+			file: "a.mp3",
+			data: append([]byte("ID3\x03\x00\x40\x00\x00\x00\x08"), "\x7f\xff\xff\xffTIT2"...),
+		},
+		{
+			name: "huge Vorbis vendor length",
+			file: "a.flac",
+			data: flacStream(streamInfo, flacBlock(flacVorbisComment, []byte("\xff\xff\xff\xffvendor\x01\x00\x00\x00\x07\x00\x00\x00TITLE=x"))),
+		},
+		{
+			name:  "zero Vorbis vendor length",
+			file:  "a.flac",
+			data:  flacStream(streamInfo, flacBlock(flacVorbisComment, vorbisComment("", "TITLE=Title"))),
+			title: "Title",
+		},
+		{
+			name: "huge Vorbis comment length",
+			file: "a.flac",
+			data: flacStream(streamInfo, flacBlock(flacVorbisComment, []byte("\x00\x00\x00\x00\x01\x00\x00\x00\xff\xff\xff\xffTITLE=x"))),
+		},
+		{
+			name:  "huge Vorbis comment count",
+			file:  "a.flac",
+			data:  flacStream(streamInfo, flacBlock(flacVorbisComment, []byte("\x00\x00\x00\x00\xff\xff\xff\xff\x0b\x00\x00\x00TITLE=Title"))),
+			title: "Title",
+		},
+		{
+			name: "truncated FLAC PICTURE block",
+			file: "a.flac",
+			data: bytes.Join([][]byte{[]byte("fLaC"), streamInfo, {0x80 | flacPicture, 0xff, 0xff, 0xff},
+				flacPictureData(pictureFrontCover, "image/png", pngData)}, nil),
+		},
+		{
+			name: "FLAC PICTURE data longer than its block",
+			file: "a.flac",
+			data: flacStream(streamInfo, flacBlock(flacPicture,
+				bytes.Replace(flacPictureData(pictureFrontCover, "image/png", pngData), []byte{0, 0, 0, byte(len(pngData))}, []byte{0xff, 0xff, 0xff, 0xff}, 1))),
+		},
+		{
+			name: "FLAC PICTURE MIME type longer than its block",
+			file: "a.flac",
+			data: flacStream(streamInfo, flacBlock(flacPicture, []byte("\x00\x00\x00\x03\xff\xff\xff\xffimage/png"))),
+		},
+		{
+			name: "Ogg page longer than the file",
+			file: "a.ogg",
+			data: oggPage(0x02, 0, []byte("\x01vorbis"), bytes.Repeat([]byte{1}, 4000))[:100],
+		},
+		{
+			name: "MP4 atom smaller than its header",
+			file: "a.m4a",
+			data: append([]byte("\x00\x00\x00\x04ftyp"), mp4Box("moov")...),
+		},
+		{
+			name: "MP4 extended size smaller than its header",
+			file: "a.m4a",
+			data: []byte("\x00\x00\x00\x01ftyp\x00\x00\x00\x00\x00\x00\x00\x08"),
+		},
+		{
+			name: "MP4 moov larger than the file",
+			file: "a.m4a",
+			data: []byte("\x03\xff\xff\xffmoov\x00\x00\x00\x00"),
+		},
+		{
+			name: "MP4 moov larger than allowed",
+			file: "a.m4a",
+			data: []byte("\xff\xff\xff\xffmoov\x00\x00\x00\x00"),
+		},
+		{
+			name: "MP4 ilst item smaller than its header",
+			file: "a.m4a",
+			data: mp4Box("moov", mp4Box("meta", []byte{0, 0, 0, 0}, mp4Box("ilst",
+				[]byte("\x00\x00\x00\x03\xa9nam"), mp4Item("\xa9ART", []byte("Artist"))))),
+		},
+		{
+			name: "MP4 data atom smaller than its header",
+			file: "a.m4a",
+			data: mp4Box("moov", mp4Box("meta", []byte{0, 0, 0, 0}, mp4Box("ilst",
+				mp4Box("\xa9nam", []byte("\x00\x00\x00\x02data\x00\x00\x00\x01"))))),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, tt.file, tt.data)
+
+			var tags *Tags
+			var err error
+			if n := allocated(func() { tags, err = ReadTags(path) }); n > maxParseAlloc {
+				t.Errorf("allocated %d bytes", n)
+			}
+			if tt.title == "" {
+				if err == nil && tags.Title != "" {
+					t.Fatalf("read title %q from a broken tag", tags.Title)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tags.Title != tt.title {
+				t.Fatalf("Title = %q, want %q", tags.Title, tt.title)
+			}
+		})
+	}
+}
+
+func checkTags(t *testing.T, tags *Tags, want Tags, picture bool) {
+	t.Helper()
+	if picture {
+		if tags.Picture == nil || tags.Picture.MIMEType != "image/png" || !bytes.Equal(tags.Picture.Data, pngData) {
+			t.Errorf("Picture = %+v, want the PNG", tags.Picture)
+		}
+	} else if tags.Picture != nil {
+		t.Errorf("Picture = %+v, want none", tags.Picture)
+	}
+	got := *tags
+	got.Picture = nil
+	if got != want {
+		t.Errorf("tags = %+v, want %+v", got, want)
+	}
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// allocated reports how many bytes fn allocates.
+func allocated(fn func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func id3v2Tag(version byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	size := len(body)
+	tag := []byte{'I', 'D', '3', version, 0, 0,
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(tag, body...)
+}
+
+func id3v2Frame(version byte, id string, data []byte) []byte {
+	size := len(data)
+	frame := []byte(id)
+	switch version {
+	case 2:
+		frame = append(frame, byte(size>>16), byte(size>>8), byte(size))
+	case 3:
+		frame = binary.BigEndian.AppendUint32(frame, uint32(size))
+		frame = append(frame, 0, 0)
+	case 4:
+		frame = append(frame, byte(size>>21&0x7f), byte(size>>14&0x7f), byte(size>>7&0x7f), byte(size&0x7f), 0, 0)
+	}
+	return append(frame, data...)
+}
+
+// id3v2Text is a Latin-1 text frame's payload.
+func id3v2Text(text string) []byte {
+	return append([]byte{0}, text...)
+}
+
+func id3v1Tag(title, artist, album, year string, track, genre byte) []byte {
+	tag := make([]byte, id3v1Size)
+	copy(tag, "TAG")
+	copy(tag[3:33], title)
+	copy(tag[33:63], artist)
+	copy(tag[63:93], album)
+	copy(tag[93:97], year)
+	tag[126] = track
+	tag[127] = genre
+	return tag
+}
+
+// flacStream puts blocks after the FLAC magic, marking the last one.
+func flacStream(blocks ...[]byte) []byte {
+	data := []byte("fLaC")
+	for i, block := range blocks {
+		if i == len(blocks)-1 {
+			block = append([]byte{block[0] | 0x80}, block[1:]...)
+		}
+		data = append(data, block...)
+	}
+	return data
+}
+
+func flacBlock(blockType byte, data []byte) []byte {
+	size := len(data)
+	return append([]byte{blockType, byte(size >> 16), byte(size >> 8), byte(size)}, data...)
+}
+
+func flacPictureData(kind byte, mimeType string, data []byte) []byte {
+	block := binary.BigEndian.AppendUint32(nil, uint32(kind))
+	block = binary.BigEndian.AppendUint32(block, uint32(len(mimeType)))
+	block = append(block, mimeType...)
+	block = binary.BigEndian.AppendUint32(block, 0) // description
+	block = append(block, make([]byte, 16)...)
+	block = binary.BigEndian.AppendUint32(block, uint32(len(data)))
+	return append(block, data...)
+}
+
+func vorbisComment(vendor string, comments ...string) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(vendor)))
+	data = append(data, vendor...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(comments)))
+	for _, comment := range comments {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(comment)))
+		data = append(data, comment...)
+	}
+	return data
+}
+
+// oggPage puts packets on one page of stream 1. A packet whose length is a
+// multiple of 255 is left open, to be continued on the next page.
+func oggPage(headerType byte, granule int64, packets ...[]byte) []byte {
+	var segments, body []byte
+	for _, packet := range packets {
+		n := len(packet)
+		for ; n >= 255; n -= 255 {
+			segments = append(segments, 255)
+		}
+		if n > 0 || len(packet) == 0 {
+			segments = append(segments, byte(n))
+		}
+		body = append(body, packet...)
+	}
+	page := []byte("OggS\x00")
+	page = append(page, headerType)
+	page = binary.LittleEndian.AppendUint64(page, uint64(granule))
+	page = binary.LittleEndian.AppendUint32(page, 1) // serial
+	page = append(page, make([]byte, 8)...)          // sequence number and checksum
+	page = append(page, byte(len(segments)))
+	page = append(page, segments...)
+	return append(page, body...)
+}
+
+func mp4Box(kind string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	atom := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	atom = append(atom, kind...)
+	return append(atom, body...)
+}
+
+// mp4Item is an ilst item holding value in its data atom.
+func mp4Item(kind string, value []byte) []byte {
+	return mp4Box(kind, mp4Box("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, value))
+}
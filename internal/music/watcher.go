@@ -0,0 +1,406 @@
+package music
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// CatalogChange describes the tracks that were added, updated or removed by
+// a rescan or by the library watcher.
+type CatalogChange struct {
+	Added   []Track  `json:"added,omitempty"`
+	Updated []Track  `json:"updated,omitempty"`
+	Removed []string `json:"removed,omitempty"` // track IDs
+}
+
+func (c CatalogChange) Empty() bool {
+	return len(c.Added) == 0 && len(c.Updated) == 0 && len(c.Removed) == 0
+}
+
+// watchDebounce is how long the watcher waits for a burst of filesystem
+// events to settle before touching the catalog. Copying a large file
+// produces many write events.
+const watchDebounce = 500 * time.Millisecond
+
+// OnChange registers fn to be called after every non-empty catalog change.
+func (s *Service) OnChange(fn func(CatalogChange)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.listeners = append(s.listeners, fn)
+}
+
+func (s *Service) notify(change CatalogChange) {
+	if change.Empty() {
+		return
+	}
+
+	s.mu.RLock()
+	listeners := make([]func(CatalogChange), len(s.listeners))
+	copy(listeners, s.listeners)
+	s.mu.RUnlock()
+
+	for _, fn := range listeners {
+		fn(change)
+	}
+}
+
+// Watch starts watching the music directory and applies changes to the
+// catalog as files are added, modified, moved or deleted.
+func (s *Service) Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.watcher = watcher
+	s.mu.Unlock()
+
+	// fsnotify is not recursive, so every directory needs its own watch
+	if _, err := s.watchTree(s.musicDir); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go s.watchLoop()
+
+	log.Printf("Watching music directory for changes: %s", s.musicDir)
+	return nil
+}
+
+// Close stops the library watcher.
+func (s *Service) Close() error {
+	s.mu.RLock()
+	watcher := s.watcher
+	s.mu.RUnlock()
+
+	if watcher == nil {
+		return nil
+	}
+	return watcher.Close()
+}
+
+// watchTree adds a watch for root and every directory below it, returning
+// the media files found along the way.
+func (s *Service) watchTree(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return s.watcher.Add(path)
+		}
+		if isMediaFile(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func (s *Service) watchLoop() {
+	pending := make(map[string]bool)
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+
+			path := filepath.Clean(event.Name)
+			pending[path] = true
+
+			// Directories created or moved into the library need watching,
+			// and may already contain files
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(path); err == nil && info.IsDir() {
+					files, err := s.watchTree(path)
+					if err != nil {
+						log.Printf("Error watching %s: %v", path, err)
+					}
+					for _, file := range files {
+						pending[file] = true
+					}
+				}
+			}
+
+			// A timer that fired but wasn't received yet must be drained,
+			// or the stale tick would flush the batch early
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(watchDebounce)
+
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Music watcher error: %v", err)
+
+		case <-timer.C:
+			paths := make([]string, 0, len(pending))
+			for path := range pending {
+				paths = append(paths, path)
+			}
+			pending = make(map[string]bool)
+
+			s.applyPathChanges(paths)
+		}
+	}
+}
+
+// applyPathChanges brings the catalog in line with the current state of
+// the given paths on disk.
+func (s *Service) applyPathChanges(paths []string) {
+	var updated []Track
+	var removed []string
+	entries := make(map[string]indexEntry)
+	artDirs := make(map[string]bool)
+
+	for _, path := range paths {
+		if isSidecarArt(path) {
+			artDirs[filepath.Dir(path)] = true
+		}
+
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			// Gone, either a single file or a whole directory
+			removed = append(removed, path)
+		case info.IsDir():
+			// Picked up through the directory's own create events
+		case isMediaFile(path):
+			track, _ := s.trackForFile(path, info)
+			updated = append(updated, track)
+			entries[path] = newIndexEntry(track, info)
+		}
+	}
+
+	// Tracks without embedded art pick up sidecar covers as they come and go
+	if len(artDirs) > 0 {
+		var affected []Track
+		s.mu.RLock()
+		for _, track := range s.catalog {
+			if artDirs[filepath.Dir(track.Path)] && !track.hasEmbeddedArt() {
+				affected = append(affected, track)
+			}
+		}
+		s.mu.RUnlock()
+
+		for _, track := range affected {
+			info, err := os.Stat(track.Path)
+			if err != nil {
+				continue
+			}
+			track.setArt(false, findSidecarArt)
+			updated = append(updated, track)
+			entries[track.Path] = newIndexEntry(track, info)
+		}
+	}
+
+	// Tags and durations are read above, outside the lock
+	s.mu.Lock()
+	change := CatalogChange{}
+
+	if len(removed) > 0 {
+		for path := range s.index.Entries {
+			if isUnderAny(path, removed) {
+				delete(s.index.Entries, path)
+			}
+		}
+	}
+	for path, entry := range entries {
+		s.index.Entries[path] = entry
+	}
+
+	if len(removed) > 0 {
+		kept := s.catalog[:0]
+		for _, track := range s.catalog {
+			if isUnderAny(track.Path, removed) {
+				change.Removed = append(change.Removed, track.ID)
+				continue
+			}
+			kept = append(kept, track)
+		}
+		s.catalog = kept
+	}
+
+	// Copies of a file already in the catalog need their own IDs, unless
+	// the original was just removed, which makes this a move
+	seen := make(map[string]string)
+	claimed := make(map[string]bool)
+	duplicate := func(track *Track) {
+		// A moved copy keeps the ID it had before
+		for _, id := range change.Removed {
+			if strings.HasPrefix(id, track.ID+"-") && !claimed[id] {
+				claimed[id] = true
+				track.setID(id)
+				return
+			}
+		}
+		track.setID(duplicateID(track.ID, s.pathID(track.Path)))
+	}
+	for i := range updated {
+		track := &updated[i]
+		if existing, ok := s.tracks.byID[track.ID]; ok && existing.Path != track.Path && !isUnderAny(existing.Path, removed) {
+			duplicate(track)
+		} else if path, ok := seen[track.ID]; ok && path != track.Path {
+			duplicate(track)
+		}
+		seen[track.ID] = track.Path
+
+		if entry, ok := s.index.Entries[track.Path]; ok {
+			entry.Track = *track
+			s.index.Entries[track.Path] = entry
+		}
+		s.index.Redirects[s.pathID(track.Path)] = track.ID
+	}
+
+	for _, track := range updated {
+		found := false
+		for i := range s.catalog {
+			if s.catalog[i].Path != track.Path {
+				continue
+			}
+			found = true
+			switch {
+			case s.catalog[i].ID != track.ID:
+				// Re-encoded or replaced, so it's a different track now
+				change.Removed = append(change.Removed, s.catalog[i].ID)
+				change.Added = append(change.Added, track)
+				s.catalog[i] = track
+			case s.catalog[i] != track:
+				s.catalog[i] = track
+				change.Updated = append(change.Updated, track)
+			}
+			break
+		}
+		if !found {
+			s.catalog = append(s.catalog, track)
+			change.Added = append(change.Added, track)
+		}
+	}
+
+	moved := change.foldMoves()
+
+	if !change.Empty() {
+		for _, id := range change.Removed {
+			s.tracks.remove(id)
+		}
+		for _, track := range change.Updated {
+			s.tracks.add(track)
+		}
+		for _, track := range change.Added {
+			s.tracks.add(track)
+		}
+		s.tracks.finish()
+		s.library = newLibrary(s.tracks.byID)
+
+		s.index.Generation++
+		s.index.LastScan = time.Now()
+	}
+	s.mu.Unlock()
+
+	s.saveIndex()
+
+	if !change.Empty() {
+		log.Printf("Catalog updated: %d added, %d updated, %d removed, %d moved",
+			len(change.Added), len(change.Updated), len(change.Removed), moved)
+	}
+	s.notify(change)
+}
+
+func isUnderAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// diffCatalogs compares two full catalogs by track ID.
+func diffCatalogs(old, new []Track) CatalogChange {
+	change := CatalogChange{}
+
+	previous := make(map[string]Track, len(old))
+	for _, track := range old {
+		previous[track.ID] = track
+	}
+
+	for _, track := range new {
+		prev, ok := previous[track.ID]
+		switch {
+		case !ok:
+			change.Added = append(change.Added, track)
+		case prev != track:
+			change.Updated = append(change.Updated, track)
+		}
+		delete(previous, track.ID)
+	}
+
+	for id := range previous {
+		change.Removed = append(change.Removed, id)
+	}
+	return change
+}
+
+// foldMoves turns tracks that were both removed and added, which is how a
+// rename or move within the library looks, into updates. It returns the
+// number of moves found.
+func (c *CatalogChange) foldMoves() int {
+	if len(c.Removed) == 0 || len(c.Added) == 0 {
+		return 0
+	}
+
+	removed := make(map[string]bool, len(c.Removed))
+	for _, id := range c.Removed {
+		removed[id] = true
+	}
+
+	moved := make(map[string]bool)
+	added := c.Added[:0]
+	for _, track := range c.Added {
+		if removed[track.ID] {
+			moved[track.ID] = true
+			c.Updated = append(c.Updated, track)
+			continue
+		}
+		added = append(added, track)
+	}
+	c.Added = added
+
+	remaining := c.Removed[:0]
+	for _, id := range c.Removed {
+		if !moved[id] {
+			remaining = append(remaining, id)
+		}
+	}
+	c.Removed = remaining
+	return len(moved)
+}
+
+// countMoves counts the updated tracks whose file path changed.
+func countMoves(previous map[string]Track, updated []Track) int {
+	moves := 0
+	for _, track := range updated {
+		if prev, ok := previous[track.ID]; ok && prev.Path != track.Path {
+			moves++
+		}
+	}
+	return moves
+}
@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"time"
+
+	"synctunes/internal/music"
+	"synctunes/internal/room"
+)
+
+// Hello is the first message on every connection.
+type Hello struct {
+	Version      int               `json:"version"`
+	Supported    []int             `json:"supported"`
+	UserID       string            `json:"user_id,omitempty"`
+	Role         string            `json:"role"`
+	Capabilities []room.Capability `json:"capabilities"`
+}
+
+// RoomState is a full snapshot of a room, as returned by room.GetState.
+type RoomState map[string]interface{}
+
+// UserEvent is the data of user_joined and user_left.
+type UserEvent struct {
+	UserID   string        `json:"user_id"`
+	UserName string        `json:"user_name,omitempty"`
+	Role     room.UserRole `json:"role,omitempty"`
+}
+
+// RoleChanged announces that the host gave a member a new role, along with
+// what it lets them do.
+type RoleChanged struct {
+	UserID       string            `json:"user_id"`
+	Role         room.UserRole     `json:"role"`
+	Capabilities []room.Capability `json:"capabilities"`
+}
+
+// HostChanged announces that a room has a new host, either handed over by
+// the previous one or by succession after they were away too long.
+type HostChanged struct {
+	HostID         string                `json:"host_id"`
+	HostName       string                `json:"host_name,omitempty"`
+	PreviousHostID string                `json:"previous_host_id,omitempty"`
+	Reason         room.HostChangeReason `json:"reason"`
+}
+
+// RoomClosed is the last message of a room that was closed. The server
+// closes the connection after it.
+type RoomClosed struct {
+	Reason room.CloseReason `json:"reason"`
+}
+
+// Presence announces a member's new status. Clients send it with only a
+// status, online or idle, when their page is shown or hidden.
+type Presence struct {
+	UserID   string              `json:"user_id,omitempty"`
+	Status   room.PresenceStatus `json:"status"`
+	LastSeen *time.Time          `json:"last_seen,omitempty"`
+}
+
+// TrackChanged announces a new current track, or none when playback
+// stopped at the end of the queue.
+type TrackChanged struct {
+	Track    *music.Track  `json:"track"`
+	Playback room.Playback `json:"playback"`
+}
+
+// Playback announces a pause, resume or seek.
+type Playback = room.Playback
+
+// QueueChanged carries a room's whole play queue, in play order.
+type QueueChanged struct {
+	Queue []room.QueueItem `json:"queue"`
+}
+
+// CatalogChanged is sent to hosts when the music library changes.
+type CatalogChanged = music.CatalogChange
+
+// Error explains why a request failed.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// TimeSync is one round of the clock synchronisation exchange, with times
+// in Unix milliseconds. The client sends t0; the server adds the time it
+// received the request (t1) and the time it replied (t2). With the time the
+// reply arrived (t3), the client estimates its clock offset as
+// ((t1-t0)+(t2-t3))/2 and the round trip as (t3-t0)-(t2-t1).
+type TimeSync struct {
+	ClientSend    float64 `json:"t0"`
+	ServerReceive float64 `json:"t1"`
+	ServerSend    float64 `json:"t2"`
+}
+
+// PlayCommand is the data of a play command.
+type PlayCommand struct {
+	TrackID string `json:"track_id"`
+}
+
+// SeekCommand is the data of a seek command.
+type SeekCommand struct {
+	Position float64 `json:"position"` // seconds
+}
+
+// EnqueueCommand is the data of an enqueue command, for a track or a whole
+// album in disc and track order. With Next set they go after the current
+// track rather than at the end of the queue.
+type EnqueueCommand struct {
+	TrackID string `json:"track_id,omitempty"`
+	AlbumID string `json:"album_id,omitempty"` // instead of a track
+	Next    bool   `json:"next"`
+}
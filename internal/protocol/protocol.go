@@ -0,0 +1,128 @@
+// Package protocol defines the messages exchanged over a room's websocket.
+//
+// Every message in either direction is an Envelope. Clients list the
+// protocol versions they speak in the "v" query parameter when connecting,
+// e.g. /ws/room?v=1; the server picks the newest one it also speaks and
+// announces it in a hello message, or sends an error and closes the
+// connection when there is none.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Version is the newest protocol version the server speaks.
+	Version = 1
+	// MinVersion is the oldest protocol version the server still speaks.
+	MinVersion = 1
+)
+
+// Messages sent by the server
+const (
+	TypeHello          = "hello"
+	TypeRoomState      = "room_state"
+	TypeUserJoined     = "user_joined"
+	TypeUserLeft       = "user_left"
+	TypePresence       = "presence"
+	TypeRoleChanged    = "role_changed"
+	TypeHostChanged    = "host_changed"
+	TypeRoomClosed     = "room_closed"
+	TypeTrackChanged   = "track_changed"
+	TypePlayback       = "playback"
+	TypeQueueChanged   = "queue_changed"
+	TypeCatalogChanged = "catalog_changed"
+	TypeAck            = "ack"
+	TypeError          = "error"
+	TypePong           = "pong"
+	// TypeBatch carries several messages at once, in order, as an array
+	// of envelopes in data.
+	TypeBatch = "batch"
+)
+
+// Messages sent by clients. Time sync replies use TypeTimeSync as well, and
+// clients report their own status with TypePresence.
+const (
+	TypePing     = "ping"
+	TypeTimeSync = "time_sync"
+	TypePlay     = "play"
+	TypePause    = "pause"
+	TypeResume   = "resume"
+	TypeSeek     = "seek"
+	TypeNext     = "next"
+	TypeEnqueue  = "enqueue"
+)
+
+// Error codes
+const (
+	ErrBadRequest         = "bad_request"
+	ErrForbidden          = "forbidden"
+	ErrNotFound           = "not_found"
+	ErrUnknownType        = "unknown_type"
+	ErrUnsupportedVersion = "unsupported_version"
+)
+
+// Envelope wraps every message. Seq numbers the events of a room in the
+// order they were broadcast; replies to a single client have none. A
+// request ID sent by a client is echoed in the reply to that request.
+type Envelope struct {
+	V         int             `json:"v"`
+	Type      string          `json:"type"`
+	Seq       uint64          `json:"seq,omitempty"`
+	TS        int64           `json:"ts,omitempty"` // server time, Unix milliseconds
+	RequestID string          `json:"request_id,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// NewEnvelope wraps data in an envelope of the given type, stamped with
+// the current server time.
+func NewEnvelope(msgType string, data interface{}) (*Envelope, error) {
+	env := &Envelope{
+		V:    Version,
+		Type: msgType,
+		TS:   time.Now().UnixMilli(),
+	}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("encoding %s message: %w", msgType, err)
+		}
+		env.Data = raw
+	}
+	return env, nil
+}
+
+// Decode unmarshals the envelope's data into v.
+func (e *Envelope) Decode(v interface{}) error {
+	if len(e.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(e.Data, v)
+}
+
+// Negotiate picks the newest version in a comma-separated list that the
+// server speaks. An empty list means the current version.
+func Negotiate(versions string) (int, error) {
+	if versions == "" {
+		return Version, nil
+	}
+
+	best := 0
+	for _, field := range strings.Split(versions, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return 0, fmt.Errorf("invalid protocol version %q", field)
+		}
+		if v >= MinVersion && v <= Version && v > best {
+			best = v
+		}
+	}
+	if best == 0 {
+		return 0, fmt.Errorf("no supported protocol version in %q, server speaks %d to %d", versions, MinVersion, Version)
+	}
+	return best, nil
+}
@@ -0,0 +1,178 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SuccessionPolicy says who becomes host of a room when its host has been
+// away for too long.
+type SuccessionPolicy string
+
+const (
+	// SucceedOff leaves the room without a host until they come back.
+	SucceedOff SuccessionPolicy = "off"
+	// SucceedCoHost hands the room to the co-host who joined first.
+	SucceedCoHost SuccessionPolicy = "co-host"
+	// SucceedLongestPresent hands the room to whoever has been in it the
+	// longest, whatever their role.
+	SucceedLongestPresent SuccessionPolicy = "longest-present"
+)
+
+// Succession is when and to whom rooms are handed on.
+type Succession struct {
+	Policy SuccessionPolicy
+	After  time.Duration // how long the host may be away first
+}
+
+var DefaultSuccession = Succession{Policy: SucceedLongestPresent, After: 5 * time.Minute}
+
+type HostChangeReason string
+
+const (
+	// HostTransferred means the host handed the room over themselves.
+	HostTransferred HostChangeReason = "transfer"
+	// HostSucceeded means the host was away and someone took over.
+	HostSucceeded HostChangeReason = "succession"
+)
+
+// HostChange is a room getting a new host. The previous host stays on as a
+// co-host.
+type HostChange struct {
+	Previous User
+	Next     User
+	Reason   HostChangeReason
+}
+
+var (
+	ErrNotHost     = errors.New("only the host can hand the room over")
+	ErrAlreadyHost = errors.New("user is already the host")
+)
+
+func ParseSuccessionPolicy(s string) (SuccessionPolicy, error) {
+	switch policy := SuccessionPolicy(s); policy {
+	case SucceedOff, SucceedCoHost, SucceedLongestPresent:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown host succession policy %q", s)
+}
+
+// TransferHost makes another member the host, as long as hostID still is.
+func (r *Room) TransferHost(hostID, userID string) (HostChange, error) {
+	r.mu.Lock()
+	if hostID != r.Host {
+		r.mu.Unlock()
+		return HostChange{}, ErrNotHost
+	}
+	next, exists := r.Listeners[userID]
+	if !exists {
+		r.mu.Unlock()
+		return HostChange{}, ErrNotMember
+	}
+	if userID == r.Host {
+		r.mu.Unlock()
+		return HostChange{}, ErrAlreadyHost
+	}
+	change := r.handOver(next, HostTransferred)
+	onHostChange := r.onHostChange
+	r.mu.Unlock()
+
+	if onHostChange != nil {
+		onHostChange(r, change)
+	}
+	return change, nil
+}
+
+// handOver makes next the host. It must be called with r.mu held.
+func (r *Room) handOver(next *User, reason HostChangeReason) HostChange {
+	if r.successionTimer != nil {
+		r.successionTimer.Stop()
+		r.successionTimer = nil
+	}
+
+	previous, exists := r.Listeners[r.Host]
+	if exists {
+		previous.Role = RoleCoHost
+	}
+	r.grants[r.Host] = RoleCoHost
+
+	r.Host = next.ID
+	next.Role = RoleHost
+	delete(r.grants, next.ID)
+	if next.leaveTimer != nil {
+		next.leaveTimer.Stop()
+		next.leaveTimer = nil
+	}
+
+	// Now that they're not the host, an away host leaves like anyone else,
+	// and an away successor may be succeeded in turn
+	if exists && previous.connections == 0 {
+		r.scheduleLeave(previous)
+	}
+	if next.connections == 0 {
+		r.scheduleSuccession()
+	}
+
+	change := HostChange{Next: *next, Reason: reason}
+	if exists {
+		change.Previous = *previous
+	}
+	return change
+}
+
+// scheduleSuccession arms the timer that hands the room on once the host
+// has been away for long enough. It must be called with r.mu held.
+func (r *Room) scheduleSuccession() {
+	if r.succession.Policy == SucceedOff || r.succession.Policy == "" {
+		return
+	}
+	if r.successionTimer != nil {
+		r.successionTimer.Stop()
+	}
+	r.successionTimer = time.AfterFunc(r.succession.After, r.successionDue)
+}
+
+func (r *Room) successionDue() {
+	r.mu.Lock()
+	host, exists := r.Listeners[r.Host]
+	// The host may have reconnected while the timer was firing
+	if r.stopped || (exists && host.connections > 0) {
+		r.mu.Unlock()
+		return
+	}
+
+	next := r.successor()
+	if next == nil {
+		// Nobody to take over yet, so look again later
+		r.scheduleSuccession()
+		r.mu.Unlock()
+		return
+	}
+	change := r.handOver(next, HostSucceeded)
+	onHostChange := r.onHostChange
+	r.mu.Unlock()
+
+	if onHostChange != nil {
+		onHostChange(r, change)
+	}
+}
+
+// successor picks the connected member the policy hands the room to, or
+// nil if there is none. It must be called with r.mu held.
+func (r *Room) successor() *User {
+	var next *User
+	for _, user := range r.Listeners {
+		if user.ID == r.Host || user.connections == 0 {
+			continue
+		}
+		if r.succession.Policy == SucceedCoHost && user.Role != RoleCoHost {
+			continue
+		}
+		if next == nil || user.JoinedAt.Before(next.JoinedAt) ||
+			(user.JoinedAt.Equal(next.JoinedAt) && user.ID < next.ID) {
+			next = user
+		}
+	}
+	return next
+}
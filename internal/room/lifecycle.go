@@ -0,0 +1,89 @@
+package room
+
+import (
+	"log"
+	"time"
+)
+
+// CloseReason says why a room was closed.
+type CloseReason string
+
+const (
+	// ClosedByHost rooms were closed by their host.
+	ClosedByHost CloseReason = "closed"
+	// ClosedIdle rooms had nobody connected for too long.
+	ClosedIdle CloseReason = "idle"
+	// ClosedExpired rooms reached their maximum age.
+	ClosedExpired CloseReason = "expired"
+)
+
+// Lifetime limits how long rooms last. A zero limit never expires them.
+type Lifetime struct {
+	Idle   time.Duration // how long a room may have nobody connected
+	MaxAge time.Duration // how long a room may exist at all
+}
+
+var DefaultLifetime = Lifetime{Idle: time.Hour, MaxAge: 24 * time.Hour}
+
+// janitorInterval is how often the janitor looks for expired rooms, at
+// most; short limits are checked more often.
+const janitorInterval = time.Minute
+
+// StartJanitor closes rooms once they have been idle or existed for longer
+// than lifetime allows. It runs until the process exits.
+func (m *Manager) StartJanitor(lifetime Lifetime) {
+	interval := janitorInterval
+	for _, limit := range []time.Duration{lifetime.Idle, lifetime.MaxAge} {
+		if limit > 0 && limit/2 < interval {
+			interval = max(limit/2, time.Second)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			m.sweep(now, lifetime)
+		}
+	}()
+}
+
+func (m *Manager) sweep(now time.Time, lifetime Lifetime) {
+	m.mu.RLock()
+	expired := make(map[string]CloseReason)
+	for id, room := range m.rooms {
+		if reason, ok := room.expired(now, lifetime); ok {
+			expired[id] = reason
+		}
+	}
+	m.mu.RUnlock()
+
+	for id, reason := range expired {
+		if m.DeleteRoom(id, reason) {
+			log.Printf("Closed room %s: %s", id, reason)
+		}
+	}
+}
+
+// expired reports whether the room has outlived lifetime, and why.
+func (r *Room) expired(now time.Time, lifetime Lifetime) (CloseReason, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if lifetime.MaxAge > 0 && now.Sub(r.CreatedAt) >= lifetime.MaxAge {
+		return ClosedExpired, true
+	}
+	if lifetime.Idle <= 0 {
+		return "", false
+	}
+	for _, user := range r.Listeners {
+		if user.connections > 0 {
+			return "", false
+		}
+	}
+	if now.Sub(r.lastActive) >= lifetime.Idle {
+		return ClosedIdle, true
+	}
+	return "", false
+}
@@ -3,6 +3,7 @@ package room
 import (
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -20,8 +21,11 @@ const (
 type UserRole string
 
 const (
-	RoleHost     UserRole = "host"
-	RoleListener UserRole = "listener"
+	RoleHost      UserRole = "host"
+	RoleCoHost    UserRole = "co_host"
+	RoleDJ        UserRole = "dj"
+	RoleModerator UserRole = "moderator"
+	RoleListener  UserRole = "listener"
 )
 
 type Room struct {
@@ -29,31 +33,120 @@ type Room struct {
 	Name          string              `json:"name"`
 	CurrentTrack  *music.Track        `json:"current_track"`
 	State         PlaybackState       `json:"state"`
-	Position      int                 `json:"position"` // current position in seconds
-	LastUpdate    time.Time           `json:"last_update"`
 	Listeners     map[string]*User    `json:"listeners"`
 	Host          string              `json:"host"`
 	CreatedAt     time.Time           `json:"created_at"`
+	Queue         []QueueItem         `json:"queue"`
+	Repeat        RepeatMode          `json:"repeat"`
+	Shuffle       bool                `json:"shuffle"`
+	ShuffleSeed   int64               `json:"shuffle_seed,omitempty"`
 	mu            sync.RWMutex        `json:"-"`
+
+	current    QueueItem   // the item being played
+	played     []QueueItem // items played since the queue last ran out, for RepeatAll
+	unshuffled []QueueItem // queue order as it would be without shuffle
+	rng        *rand.Rand  // seeded shuffle generator
+
+	anchorTime     time.Time
+	anchorPosition time.Duration
+	rate           float64 // playback speed, 1 is normal
+
+	advanceTimer   *time.Timer
+	playGeneration uint64 // bumped to invalidate a pending advance
+	onAdvance      func(*Room)
+	onLeave        func(*Room, User)
+
+	succession      Succession
+	successionTimer *time.Timer // hands the room on once the host has been gone long enough
+	onHostChange    func(*Room, HostChange)
+	stopped         bool // set once the room is deleted
+
+	lastActive time.Time // when someone last connected or disconnected
+
+	// grants are the roles the host gave members, by user ID. They outlast
+	// the grace period, so members who were away too long get their role
+	// back when they rejoin.
+	grants map[string]UserRole
 }
   
 type User struct {
-	ID   string   `json:"id"`
-	Name string   `json:"name"`
-	Role UserRole `json:"role"`
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Role      UserRole       `json:"role"`
+	AccountID string         `json:"account_id,omitempty"` // set when signed in to an account
+	Status    PresenceStatus `json:"status"`
+	LastSeen  time.Time      `json:"last_seen"`
+	JoinedAt  time.Time      `json:"joined_at"`
+
+	connections     int         // open websocket connections, one per tab
+	leaveTimer      *time.Timer // removes the user once the grace period is up
+	leaveGeneration uint64      // bumped whenever leaveTimer is armed
 }
 
 type Manager struct {
-	rooms map[string]*Room
-	mu    sync.RWMutex
+	rooms        map[string]*Room
+	mu           sync.RWMutex
+	onAdvance    func(*Room)
+	onLeave      func(*Room, User)
+	onHostChange func(*Room, HostChange)
+	onClose      func(*Room, CloseReason)
+	succession   Succession
 }
 
 func NewManager() *Manager {
 	return &Manager{
-		rooms: make(map[string]*Room),
+		rooms:      make(map[string]*Room),
+		succession: DefaultSuccession,
 	}
 }
 
+// OnAdvance registers fn to be called after a room moves on to the next
+// queued track by itself. It must be called before rooms are created.
+func (m *Manager) OnAdvance(fn func(*Room)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onAdvance = fn
+}
+
+// OnLeave registers fn to be called after a user has been disconnected
+// for longer than the grace period and is removed from a room. It must be
+// called before rooms are created.
+func (m *Manager) OnLeave(fn func(*Room, User)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onLeave = fn
+}
+
+// OnHostChange registers fn to be called after a room gets a new host,
+// whether handed over or by succession. It must be called before rooms are
+// created.
+func (m *Manager) OnHostChange(fn func(*Room, HostChange)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onHostChange = fn
+}
+
+// OnClose registers fn to be called after a room is deleted, whether by
+// its host or by the janitor.
+func (m *Manager) OnClose(fn func(*Room, CloseReason)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.onClose = fn
+}
+
+// SetSuccession chooses who takes over rooms whose host has gone. It must
+// be called before rooms are created.
+func (m *Manager) SetSuccession(succession Succession) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.succession = succession
+}
+
 func (m *Manager) CreateRoom(id, name, hostID string) *Room {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -62,20 +155,35 @@ func (m *Manager) CreateRoom(id, name, hostID string) *Room {
 		ID:         id,
 		Name:       name,
 		State:      StateStopped,
-		Position:   0,
-		LastUpdate: time.Now(),
 		Listeners:  make(map[string]*User),
 		Host:       hostID,
 		CreatedAt:  time.Now(),
+		Queue:      make([]QueueItem, 0),
+		Repeat:     RepeatOff,
+		anchorTime: time.Now(),
+		rate:       1,
+		onAdvance:  m.onAdvance,
+		onLeave:    m.onLeave,
+		grants:     make(map[string]UserRole),
+
+		succession:   m.succession,
+		onHostChange: m.onHostChange,
+		lastActive:   time.Now(),
 	}
 
 	// Add the host as a user
 	room.Listeners[hostID] = &User{
-		ID:   hostID,
-		Name: "Host",
-		Role: RoleHost,
+		ID:       hostID,
+		Name:     "Host",
+		Role:     RoleHost,
+		Status:   StatusAway,
+		LastSeen: time.Now(),
+		JoinedAt: time.Now(),
 	}
 
+	// Like anyone else, the host is away until they connect
+	room.scheduleSuccession()
+
 	m.rooms[id] = room
 	return room
 }
@@ -88,11 +196,22 @@ func (m *Manager) GetRoom(id string) (*Room, bool) {
 	return room, exists
 }
 
-func (m *Manager) DeleteRoom(id string) {
+// DeleteRoom closes a room for good, and reports whether it existed.
+func (m *Manager) DeleteRoom(id string, reason CloseReason) bool {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	
+	room, exists := m.rooms[id]
 	delete(m.rooms, id)
+	onClose := m.onClose
+	m.mu.Unlock()
+	
+	if !exists {
+		return false
+	}
+	room.stop()
+	if onClose != nil {
+		onClose(room, reason)
+	}
+	return true
 }
 
 func (m *Manager) JoinRoom(roomID, userID, userName string) error {
@@ -107,12 +226,21 @@ func (m *Manager) JoinRoom(roomID, userID, userName string) error {
 	room.mu.Lock()
 	defer room.mu.Unlock()
 
-	room.Listeners[userID] = &User{
-		ID:   userID,
-		Name: userName,
-		Role: RoleListener,
+	user := &User{
+		ID:       userID,
+		Name:     userName,
+		Role:     RoleListener,
+		Status:   StatusAway,
+		LastSeen: time.Now(),
+		JoinedAt: time.Now(),
 	}
+	if role, granted := room.grants[userID]; granted {
+		user.Role = role
+	}
+	room.Listeners[userID] = user
 
+	// Members count as away until their first connection
+	room.scheduleLeave(user)
 	return nil
 }
 
@@ -128,22 +256,13 @@ func (m *Manager) LeaveRoom(roomID, userID string) error {
 	room.mu.Lock()
 	defer room.mu.Unlock()
 
+	if user, exists := room.Listeners[userID]; exists && user.leaveTimer != nil {
+		user.leaveTimer.Stop()
+	}
 	delete(room.Listeners, userID)
 	return nil
 }
 
-func (r *Room) CanControlPlayback(userID string) bool {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	
-	user, exists := r.Listeners[userID]
-	if !exists {
-		return false
-	}
-	
-	return user.Role == RoleHost
-}
-
 func (r *Room) IsHost(userID string) bool {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -161,14 +280,45 @@ func (r *Room) GetUserRole(userID string) UserRole {
 	return RoleListener
 }
 
+// LinkAccount marks a member as the person signed in to an account, going
+// by the given name.
+func (r *Room) LinkAccount(userID, accountID, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, exists := r.Listeners[userID]; exists {
+		user.AccountID = accountID
+		user.Name = name
+	}
+}
+
+// AccountMember returns the member signed in to an account, if they are in
+// the room.
+func (r *Room) AccountMember(accountID string) (User, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, user := range r.Listeners {
+		if user.AccountID == accountID {
+			return *user, true
+		}
+	}
+	return User{}, false
+}
+
 func (r *Room) PlayTrack(track *music.Track) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	r.current = newQueueItem(track, "")
+	r.play(track)
+}
+
+func (r *Room) play(track *music.Track) {
 	r.CurrentTrack = track
 	r.State = StatePlaying
-	r.Position = 0
-	r.LastUpdate = time.Now()
+	r.setPosition(time.Now(), 0)
+	r.scheduleAdvance()
 }
 
 func (r *Room) Pause() {
@@ -176,11 +326,10 @@ func (r *Room) Pause() {
 	defer r.mu.Unlock()
 
 	if r.State == StatePlaying {
-		// Update position based on time elapsed
-		elapsed := time.Since(r.LastUpdate).Seconds()
-		r.Position += int(elapsed)
+		now := time.Now()
+		r.setPosition(now, r.positionAt(now))
 		r.State = StatePaused
-		r.LastUpdate = time.Now()
+		r.scheduleAdvance()
 	}
 }
 
@@ -189,39 +338,35 @@ func (r *Room) Resume() {
 	defer r.mu.Unlock()
 
 	if r.State == StatePaused {
+		r.setPosition(time.Now(), r.anchorPosition)
 		r.State = StatePlaying
-		r.LastUpdate = time.Now()
+		r.scheduleAdvance()
 	}
 }
 
-func (r *Room) Seek(position int) {
+// Seek moves playback to position, clamped to the length of the current
+// track.
+func (r *Room) Seek(position time.Duration) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.Position = position
-	r.LastUpdate = time.Now()
+	r.setPosition(time.Now(), position)
+	r.scheduleAdvance()
 }
 
+// GetCurrentPosition returns the playback position in whole seconds.
 func (r *Room) GetCurrentPosition() int {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	if r.State == StatePlaying {
-		elapsed := time.Since(r.LastUpdate).Seconds()
-		return r.Position + int(elapsed)
-	}
-	return r.Position
+	return int(r.CurrentPosition() / time.Second)
 }
 
 func (r *Room) GetState() map[string]interface{} {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	currentPosition := r.Position
-	if r.State == StatePlaying {
-		elapsed := time.Since(r.LastUpdate).Seconds()
-		currentPosition += int(elapsed)
-	}
+	// The position and timestamp are taken together, so clients can work
+	// out the position at any later moment on the server's clock
+	now := time.Now()
+	position := r.positionAt(now)
 
 	listeners := make([]User, 0, len(r.Listeners))
 	for _, user := range r.Listeners {
@@ -233,10 +378,17 @@ func (r *Room) GetState() map[string]interface{} {
 		"name":           r.Name,
 		"current_track":  r.CurrentTrack,
 		"state":          r.State,
-		"position":       currentPosition,
+		"position":       int(position / time.Second),
+		"position_ms":    position.Milliseconds(),
+		"rate":           r.rate,
+		"server_time":    now.UnixMilli(),
 		"listeners":      listeners,
 		"host":           r.Host,
 		"created_at":     r.CreatedAt,
+		"queue":          r.queueCopy(),
+		"repeat":         r.Repeat,
+		"shuffle":        r.Shuffle,
+		"shuffle_seed":   r.ShuffleSeed,
 	}
 }
 
@@ -0,0 +1,92 @@
+package room
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+type RepeatMode string
+
+const (
+	RepeatOff RepeatMode = "off"
+	RepeatOne RepeatMode = "one"
+	RepeatAll RepeatMode = "all"
+)
+
+func ParseRepeatMode(s string) (RepeatMode, error) {
+	switch mode := RepeatMode(s); mode {
+	case RepeatOff, RepeatOne, RepeatAll:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown repeat mode %q", s)
+}
+
+// SetRepeat changes what happens when the current track ends: with
+// RepeatOne it plays again, with RepeatAll everything played goes back into
+// the queue once it runs out.
+func (r *Room) SetRepeat(mode RepeatMode) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Repeat = mode
+	if mode != RepeatAll {
+		r.played = nil
+	}
+}
+
+// SetShuffle turns shuffle on or off. Turning it on reorders the queue with
+// a generator seeded by seed, so the order is reproducible; tracks queued
+// later land at seeded random positions too. Turning it off restores the
+// order the queue had before.
+func (r *Room) SetShuffle(on bool, seed int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !on {
+		if r.Shuffle {
+			r.Queue = restoreOrder(r.unshuffled, r.Queue)
+		}
+		r.Shuffle = false
+		r.ShuffleSeed = 0
+		r.unshuffled = nil
+		r.rng = nil
+		return
+	}
+
+	if !r.Shuffle {
+		r.unshuffled = append([]QueueItem(nil), r.Queue...)
+	}
+	r.Shuffle = true
+	r.ShuffleSeed = seed
+	r.rng = rand.New(rand.NewSource(seed))
+	r.shuffle(r.Queue)
+}
+
+func (r *Room) shuffle(items []QueueItem) {
+	r.rng.Shuffle(len(items), func(i, j int) {
+		items[i], items[j] = items[j], items[i]
+	})
+}
+
+// restoreOrder puts the items of queue that are in original back in that
+// order, followed by any others.
+func restoreOrder(original, queue []QueueItem) []QueueItem {
+	pending := make(map[string]bool, len(queue))
+	for _, item := range queue {
+		pending[item.ID] = true
+	}
+
+	restored := make([]QueueItem, 0, len(queue))
+	for _, item := range original {
+		if pending[item.ID] {
+			restored = append(restored, item)
+			delete(pending, item.ID)
+		}
+	}
+	for _, item := range queue {
+		if pending[item.ID] {
+			restored = append(restored, item)
+		}
+	}
+	return restored
+}
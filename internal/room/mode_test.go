@@ -0,0 +1,139 @@
+package room
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestShuffleIsSeeded(t *testing.T) {
+	shuffled := func(seed int64) []string {
+		rm, _ := testRoom(t)
+		rm.EnqueueTracks(testTracks(20, time.Hour), "host", false)
+		rm.SetShuffle(true, seed)
+		// Tracks queued later land at seeded positions too
+		rm.EnqueueTracks(testTracks(1, time.Hour), "host", false)
+		return queuedTracks(rm)
+	}
+
+	first := shuffled(42)
+	if again := shuffled(42); !slices.Equal(again, first) {
+		t.Fatalf("the same seed shuffled to %v, then %v", first, again)
+	}
+	if other := shuffled(43); slices.Equal(other, first) {
+		t.Fatalf("another seed shuffled to the same %v", first)
+	}
+
+	rm, _ := testRoom(t)
+	rm.EnqueueTracks(testTracks(20, time.Hour), "host", false)
+	unshuffled := queuedTracks(rm)
+	rm.SetShuffle(true, 42)
+	if got := queuedTracks(rm); slices.Equal(got, unshuffled) {
+		t.Fatalf("shuffling left the queue as it was: %v", got)
+	}
+}
+
+func TestShuffleOffRestoresOrder(t *testing.T) {
+	rm, _ := testRoom(t)
+	tracks := testTracks(12, time.Hour)
+	items := rm.EnqueueTracks(tracks[:6], "host", false)
+	rm.SetShuffle(true, 7)
+
+	// Queue, remove and play tracks while shuffled
+	added := rm.Enqueue(&tracks[6], "host")
+	rm.Enqueue(&tracks[7], "host")
+	rm.EnqueueTracks(tracks[8:10], "host", false)
+	rm.InsertNext(&tracks[10], "host")
+	rm.EnqueueTracks(tracks[11:], "host", true)
+	for _, id := range []string{items[2].ID, added.ID} {
+		if err := rm.RemoveFromQueue(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rm.Next()
+	played := snapshot(rm).track
+
+	rm.SetShuffle(false, 0)
+	var want []string
+	for _, id := range []string{"t11", "t10", "t0", "t1", "t3", "t4", "t5", "t7", "t8", "t9"} {
+		if id != played {
+			want = append(want, id)
+		}
+	}
+	if got := queuedTracks(rm); !slices.Equal(got, want) {
+		t.Fatalf("queue = %v after turning shuffle off, want %v", got, want)
+	}
+}
+
+func TestRepeatOne(t *testing.T) {
+	rm, advanced := testRoom(t)
+	rm.EnqueueTracks(testTracks(2, time.Hour), "host", false)
+	rm.SetRepeat(RepeatOne)
+	rm.Next()
+	rm.Seek(59 * time.Minute)
+
+	rm.trackEnded(generation(rm))
+	expectAdvance(t, advanced, nowPlaying{"t0", StatePlaying})
+	if position := rm.CurrentPosition(); position > time.Minute {
+		t.Fatalf("position = %v, want the track to start over", position)
+	}
+	if got := queuedTracks(rm); !slices.Equal(got, []string{"t1"}) {
+		t.Fatalf("queue = %v, want [t1]", got)
+	}
+
+	// Skipping still moves on
+	rm.Next()
+	if got := snapshot(rm); got != (nowPlaying{"t1", StatePlaying}) {
+		t.Fatalf("now playing %+v after skipping", got)
+	}
+}
+
+func TestRepeatAll(t *testing.T) {
+	// cycles plays the queue round several times, and returns the order
+	// each time round
+	cycles := func(shuffle bool) [][]string {
+		rm, _ := testRoom(t)
+		rm.EnqueueTracks(testTracks(8, time.Hour), "host", false)
+		rm.SetRepeat(RepeatAll)
+		if shuffle {
+			rm.SetShuffle(true, 7)
+		}
+
+		var cycles [][]string
+		for i := 0; i < 5; i++ {
+			var cycle []string
+			for j := 0; j < 8; j++ {
+				if !rm.Next() {
+					t.Fatal("the queue ran out")
+				}
+				cycle = append(cycle, snapshot(rm).track)
+			}
+			cycles = append(cycles, cycle)
+		}
+		return cycles
+	}
+
+	unshuffled := cycles(false)
+	for _, cycle := range unshuffled {
+		if !slices.Equal(cycle, unshuffled[0]) || !slices.IsSorted(cycle) {
+			t.Fatalf("played %v, want every time round in queue order", unshuffled)
+		}
+	}
+
+	shuffled := cycles(true)
+	reshuffled := false
+	for _, cycle := range shuffled {
+		sorted := slices.Clone(cycle)
+		slices.Sort(sorted)
+		if !slices.Equal(sorted, unshuffled[0]) {
+			t.Fatalf("played %v in one time round, want every track once", cycle)
+		}
+		reshuffled = reshuffled || !slices.Equal(cycle, shuffled[0])
+	}
+	if !reshuffled {
+		t.Fatalf("played %v, want a new order each time round", shuffled)
+	}
+	if again := cycles(true); !slices.Equal(again[len(again)-1], shuffled[len(shuffled)-1]) {
+		t.Fatalf("the same seed played %v, then %v", shuffled, again)
+	}
+}
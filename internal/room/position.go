@@ -0,0 +1,87 @@
+package room
+
+import (
+	"time"
+
+	"synctunes/internal/music"
+)
+
+// The playback position is anchored rather than stored: at anchorTime it
+// was anchorPosition, and while playing it advances at rate. Every state
+// change re-anchors, so no precision is lost however often playback is
+// paused, resumed or seeked. anchorTime keeps Go's monotonic clock reading,
+// so wall clock adjustments don't move the position.
+
+// positionAt returns the playback position at now, without running past
+// the end of the track. It must be called with r.mu held.
+func (r *Room) positionAt(now time.Time) time.Duration {
+	position := r.anchorPosition
+	if r.State == StatePlaying {
+		position += time.Duration(float64(now.Sub(r.anchorTime)) * r.rate)
+	}
+	return r.clampPosition(position)
+}
+
+// setPosition re-anchors playback at position as of now. It must be called
+// with r.mu held.
+func (r *Room) setPosition(now time.Time, position time.Duration) {
+	r.anchorTime = now
+	r.anchorPosition = r.clampPosition(position)
+}
+
+func (r *Room) clampPosition(position time.Duration) time.Duration {
+	if position < 0 {
+		return 0
+	}
+	if length := trackLength(r.CurrentTrack); length > 0 && position > length {
+		return length
+	}
+	return position
+}
+
+func trackLength(track *music.Track) time.Duration {
+	if track == nil {
+		return 0
+	}
+	return time.Duration(track.DurationMs) * time.Millisecond
+}
+
+// CurrentPosition returns the exact playback position.
+func (r *Room) CurrentPosition() time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.positionAt(time.Now())
+}
+
+// Playback is a consistent snapshot of where playback is: PositionMs was
+// the position at ServerTime.
+type Playback struct {
+	State      PlaybackState `json:"state"`
+	PositionMs int64         `json:"position_ms"`
+	Rate       float64       `json:"rate"`
+	ServerTime int64         `json:"server_time"` // Unix milliseconds
+}
+
+// Playback returns where playback is right now.
+func (r *Room) Playback() Playback {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	return Playback{
+		State:      r.State,
+		PositionMs: r.positionAt(now).Milliseconds(),
+		Rate:       r.rate,
+		ServerTime: now.UnixMilli(),
+	}
+}
+
+// NowPlaying returns the current track together with a playback snapshot.
+func (r *Room) NowPlaying() (*music.Track, Playback) {
+	r.mu.RLock()
+	track := r.CurrentTrack
+	r.mu.RUnlock()
+
+	return track, r.Playback()
+}
@@ -0,0 +1,156 @@
+package room
+
+import (
+	"fmt"
+	"time"
+)
+
+type PresenceStatus string
+
+const (
+	// StatusOnline users have the room open and in front of them.
+	StatusOnline PresenceStatus = "online"
+	// StatusIdle users are connected, but their tab is in the background.
+	StatusIdle PresenceStatus = "idle"
+	// StatusAway users have no connection, but may still come back.
+	StatusAway PresenceStatus = "away"
+)
+
+// PresenceGrace is how long a member may stay disconnected before leaving
+// the room, so reloading a page or a brief network drop goes unnoticed.
+// The host never leaves.
+const PresenceGrace = 30 * time.Second
+
+func ParsePresenceStatus(s string) (PresenceStatus, error) {
+	switch status := PresenceStatus(s); status {
+	case StatusOnline, StatusIdle:
+		return status, nil
+	}
+	return "", fmt.Errorf("unknown presence status %q", s)
+}
+
+// GetUser returns a copy of a member of the room.
+func (r *Room) GetUser(userID string) (User, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, exists := r.Listeners[userID]
+	if !exists {
+		return User{}, false
+	}
+	return *user, true
+}
+
+// Connect records a new connection from a member, one per open tab. It
+// returns the member and whether their status changed; connections from
+// anyone who isn't a member are not tracked.
+func (r *Room) Connect(userID string) (User, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, exists := r.Listeners[userID]
+	if !exists {
+		return User{}, false
+	}
+
+	if user.leaveTimer != nil {
+		user.leaveTimer.Stop()
+		user.leaveTimer = nil
+	}
+	if userID == r.Host && r.successionTimer != nil {
+		r.successionTimer.Stop()
+		r.successionTimer = nil
+	}
+	user.connections++
+	user.LastSeen = time.Now()
+	r.lastActive = user.LastSeen
+
+	changed := user.Status == StatusAway
+	if changed {
+		user.Status = StatusOnline
+	}
+	return *user, changed
+}
+
+// Disconnect records a closed connection. When a member's last one closes
+// they are away, and leave the room unless they reconnect within
+// PresenceGrace.
+func (r *Room) Disconnect(userID string) (User, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, exists := r.Listeners[userID]
+	if !exists || user.connections == 0 {
+		return User{}, false
+	}
+
+	user.connections--
+	user.LastSeen = time.Now()
+	r.lastActive = user.LastSeen
+	if user.connections > 0 {
+		return *user, false
+	}
+
+	user.Status = StatusAway
+	if userID == r.Host {
+		r.scheduleSuccession()
+	} else {
+		r.scheduleLeave(user)
+	}
+	return *user, true
+}
+
+// SetPresence records a connected member's status as their client reports
+// it, and returns whether it changed.
+func (r *Room) SetPresence(userID string, status PresenceStatus) (User, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, exists := r.Listeners[userID]
+	if !exists || user.connections == 0 {
+		return User{}, false
+	}
+
+	user.LastSeen = time.Now()
+	changed := user.Status != status
+	user.Status = status
+	return *user, changed
+}
+
+// scheduleLeave arms the timer that removes a disconnected member once the
+// grace period is up. It must be called with r.mu held.
+func (r *Room) scheduleLeave(user *User) {
+	if user.ID == r.Host {
+		return
+	}
+	if user.leaveTimer != nil {
+		user.leaveTimer.Stop()
+	}
+	user.leaveGeneration++
+	generation := user.leaveGeneration
+	user.leaveTimer = time.AfterFunc(PresenceGrace, func() {
+		r.graceExpired(user, generation)
+	})
+}
+
+// graceExpired removes a member whose leave timer fired, unless a later
+// timer has replaced it.
+func (r *Room) graceExpired(user *User, generation uint64) {
+	r.mu.Lock()
+	// Stopping a timer that has already fired doesn't stop the callback, so
+	// the member may have reconnected, been made host, or even rejoined or
+	// disconnected again with a new grace period, while it was firing
+	if r.Listeners[user.ID] != user || user.connections > 0 || user.ID == r.Host || user.leaveGeneration != generation {
+		r.mu.Unlock()
+		return
+	}
+	// Only their place in the room goes; their role waits for them
+	delete(r.Listeners, user.ID)
+	left := *user
+	onLeave := r.onLeave
+	r.mu.Unlock()
+
+	if onLeave != nil {
+		onLeave(r, left)
+	}
+}
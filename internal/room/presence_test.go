@@ -0,0 +1,57 @@
+package room
+
+import "testing"
+
+func TestStaleLeaveTimer(t *testing.T) {
+	m := NewManager()
+	m.SetSuccession(Succession{Policy: SucceedOff})
+	rm := m.CreateRoom("room", "Room", "host")
+	defer m.DeleteRoom("room", ClosedByHost)
+
+	if err := m.JoinRoom("room", "guest", "Guest"); err != nil {
+		t.Fatal(err)
+	}
+	rm.Connect("guest")
+	rm.Disconnect("guest")
+	// This timer fires, but has to wait for the lock...
+	user, stale := pendingLeave(rm, "guest")
+
+	// ...while the guest reconnects and disconnects again
+	rm.Connect("guest")
+	rm.Disconnect("guest")
+	rm.graceExpired(user, stale)
+	if _, ok := rm.GetUser("guest"); !ok {
+		t.Fatal("an earlier disconnection's timer removed the guest")
+	}
+
+	// A timer firing as the guest reconnects doesn't remove them either
+	user, generation := pendingLeave(rm, "guest")
+	rm.Connect("guest")
+	rm.graceExpired(user, generation)
+	if _, ok := rm.GetUser("guest"); !ok {
+		t.Fatal("the timer removed a connected guest")
+	}
+
+	// But the grace period of the last disconnection counts
+	rm.Disconnect("guest")
+	expireGrace(rm, "guest")
+	if _, ok := rm.GetUser("guest"); ok {
+		t.Fatal("guest still in the room after the grace period")
+	}
+}
+
+// pendingLeave returns a member and the generation of their current leave
+// timer, which is what the timer calls graceExpired with.
+func pendingLeave(r *Room, userID string) (*User, uint64) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user := r.Listeners[userID]
+	return user, user.leaveGeneration
+}
+
+// expireGrace fires a member's leave timer now rather than after
+// PresenceGrace.
+func expireGrace(r *Room, userID string) {
+	r.graceExpired(pendingLeave(r, userID))
+}
@@ -0,0 +1,256 @@
+package room
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"synctunes/internal/music"
+)
+
+// QueueItem is one entry in a room's play queue. Items have their own ID
+// so the same track can be queued more than once.
+type QueueItem struct {
+	ID      string       `json:"id"`
+	Track   *music.Track `json:"track"`
+	AddedBy string       `json:"added_by,omitempty"`
+	AddedAt time.Time    `json:"added_at"`
+}
+
+func newQueueItem(track *music.Track, userID string) QueueItem {
+	return QueueItem{
+		ID:      uuid.New().String(),
+		Track:   track,
+		AddedBy: userID,
+		AddedAt: time.Now(),
+	}
+}
+
+// GetQueue returns a copy of the upcoming tracks, in play order.
+func (r *Room) GetQueue() []QueueItem {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.queueCopy()
+}
+
+func (r *Room) queueCopy() []QueueItem {
+	queue := make([]QueueItem, len(r.Queue))
+	copy(queue, r.Queue)
+	return queue
+}
+
+// Enqueue adds a track to the end of the queue, or somewhere random in it
+// when shuffling.
+func (r *Room) Enqueue(track *music.Track, userID string) QueueItem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item := newQueueItem(track, userID)
+	if r.Shuffle {
+		i := r.rng.Intn(len(r.Queue) + 1)
+		r.Queue = append(r.Queue[:i], append([]QueueItem{item}, r.Queue[i:]...)...)
+		r.unshuffled = append(r.unshuffled, item)
+	} else {
+		r.Queue = append(r.Queue, item)
+	}
+	return item
+}
+
+// InsertNext adds a track to the front of the queue, so it plays after the
+// current one.
+func (r *Room) InsertNext(track *music.Track, userID string) QueueItem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item := newQueueItem(track, userID)
+	r.Queue = append([]QueueItem{item}, r.Queue...)
+	if r.Shuffle {
+		r.unshuffled = append([]QueueItem{item}, r.unshuffled...)
+	}
+	return item
+}
+
+// EnqueueTracks adds several tracks together, such as an album, keeping
+// them in order. They go at the end of the queue, at the front with next
+// set, or somewhere random in it when shuffling.
+func (r *Room) EnqueueTracks(tracks []music.Track, userID string, next bool) []QueueItem {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items := make([]QueueItem, len(tracks))
+	for i := range tracks {
+		items[i] = newQueueItem(&tracks[i], userID)
+	}
+
+	i := len(r.Queue)
+	switch {
+	case next:
+		i = 0
+	case r.Shuffle:
+		i = r.rng.Intn(len(r.Queue) + 1)
+	}
+	r.Queue = append(r.Queue[:i], append(items, r.Queue[i:]...)...)
+	if r.Shuffle {
+		if next {
+			r.unshuffled = append(append([]QueueItem(nil), items...), r.unshuffled...)
+		} else {
+			r.unshuffled = append(r.unshuffled, items...)
+		}
+	}
+	return items
+}
+
+// RemoveFromQueue removes the queue item with the given ID.
+func (r *Room) RemoveFromQueue(itemID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.queueIndex(itemID)
+	if i < 0 {
+		return fmt.Errorf("queue item not found")
+	}
+	r.Queue = append(r.Queue[:i], r.Queue[i+1:]...)
+	return nil
+}
+
+// MoveInQueue moves a queue item to index, clamped to the queue bounds.
+func (r *Room) MoveInQueue(itemID string, index int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.queueIndex(itemID)
+	if i < 0 {
+		return fmt.Errorf("queue item not found")
+	}
+	index = min(max(index, 0), len(r.Queue)-1)
+
+	item := r.Queue[i]
+	r.Queue = append(r.Queue[:i], r.Queue[i+1:]...)
+	r.Queue = append(r.Queue[:index], append([]QueueItem{item}, r.Queue[index:]...)...)
+	return nil
+}
+
+// ClearQueue removes every upcoming track.
+func (r *Room) ClearQueue() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Queue = nil
+	r.played = nil
+	r.unshuffled = nil
+}
+
+func (r *Room) queueIndex(itemID string) int {
+	for i, item := range r.Queue {
+		if item.ID == itemID {
+			return i
+		}
+	}
+	return -1
+}
+
+// Next starts the next queued track. When the queue is empty playback
+// stops, and Next returns false.
+func (r *Room) Next() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.next()
+}
+
+func (r *Room) next() bool {
+	if r.Repeat == RepeatAll && r.current.Track != nil {
+		r.played = append(r.played, r.current)
+	}
+
+	// Start over once everything has played
+	if len(r.Queue) == 0 && r.Repeat == RepeatAll && len(r.played) > 0 {
+		r.Queue = r.played
+		r.played = nil
+		if r.Shuffle {
+			r.shuffle(r.Queue)
+		}
+	}
+
+	if len(r.Queue) == 0 {
+		r.current = QueueItem{}
+		r.State = StateStopped
+		r.setPosition(time.Now(), 0)
+		r.scheduleAdvance()
+		return false
+	}
+
+	item := r.Queue[0]
+	r.Queue = r.Queue[1:]
+	r.current = item
+	r.play(item.Track)
+	return true
+}
+
+// scheduleAdvance arms the timer that moves on to the next queued track
+// when the current one ends. It must be called with r.mu held whenever the
+// playback state or position changes.
+func (r *Room) scheduleAdvance() {
+	if r.advanceTimer != nil {
+		r.advanceTimer.Stop()
+		r.advanceTimer = nil
+	}
+	r.playGeneration++
+
+	if r.State != StatePlaying || r.CurrentTrack == nil || r.CurrentTrack.DurationMs <= 0 {
+		return
+	}
+
+	remaining := trackLength(r.CurrentTrack) - r.positionAt(time.Now())
+	generation := r.playGeneration
+	r.advanceTimer = time.AfterFunc(time.Duration(float64(remaining)/r.rate), func() {
+		r.trackEnded(generation)
+	})
+}
+
+// trackEnded advances the queue, or replays the track with RepeatOne,
+// unless playback changed since the timer was armed.
+func (r *Room) trackEnded(generation uint64) {
+	r.mu.Lock()
+	if generation != r.playGeneration {
+		r.mu.Unlock()
+		return
+	}
+	if r.Repeat == RepeatOne && r.CurrentTrack != nil {
+		r.play(r.CurrentTrack)
+	} else {
+		r.next()
+	}
+	onAdvance := r.onAdvance
+	r.mu.Unlock()
+
+	if onAdvance != nil {
+		onAdvance(r)
+	}
+}
+
+// stop cancels any pending queue advance, and any pending departures.
+func (r *Room) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.advanceTimer != nil {
+		r.advanceTimer.Stop()
+		r.advanceTimer = nil
+	}
+	r.playGeneration++
+
+	if r.successionTimer != nil {
+		r.successionTimer.Stop()
+		r.successionTimer = nil
+	}
+	r.stopped = true
+
+	for _, user := range r.Listeners {
+		if user.leaveTimer != nil {
+			user.leaveTimer.Stop()
+		}
+	}
+}
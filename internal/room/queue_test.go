@@ -0,0 +1,220 @@
+package room
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"synctunes/internal/music"
+)
+
+// nowPlaying is what a room is doing, as seen by an OnAdvance callback.
+type nowPlaying struct {
+	track string // the current track's ID, if any
+	state PlaybackState
+}
+
+func snapshot(r *Room) nowPlaying {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var track string
+	if r.State != StateStopped {
+		track = r.CurrentTrack.ID
+	}
+	return nowPlaying{track: track, state: r.State}
+}
+
+// testRoom creates a room whose every advance is sent on the returned
+// channel.
+func testRoom(t *testing.T) (*Room, <-chan nowPlaying) {
+	t.Helper()
+	advanced := make(chan nowPlaying, 16)
+	m := NewManager()
+	m.SetSuccession(Succession{Policy: SucceedOff})
+	m.OnAdvance(func(r *Room) {
+		advanced <- snapshot(r)
+	})
+	rm := m.CreateRoom("room", "Room", "host")
+	t.Cleanup(func() { m.DeleteRoom("room", ClosedByHost) })
+	return rm, advanced
+}
+
+// testTracks makes n tracks called t0, t1 and so on.
+func testTracks(n int, length time.Duration) []music.Track {
+	tracks := make([]music.Track, n)
+	for i := range tracks {
+		tracks[i] = music.Track{ID: fmt.Sprint("t", i), DurationMs: length.Milliseconds()}
+	}
+	return tracks
+}
+
+// queuedTracks returns the IDs of the queued tracks, in order.
+func queuedTracks(r *Room) []string {
+	var ids []string
+	for _, item := range r.GetQueue() {
+		ids = append(ids, item.Track.ID)
+	}
+	return ids
+}
+
+func generation(r *Room) uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.playGeneration
+}
+
+func expectAdvance(t *testing.T, advanced <-chan nowPlaying, want nowPlaying) {
+	t.Helper()
+	select {
+	case got := <-advanced:
+		if got != want {
+			t.Fatalf("advanced to %+v, want %+v", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("never advanced to %+v", want)
+	}
+}
+
+func expectNoAdvance(t *testing.T, advanced <-chan nowPlaying, wait time.Duration) {
+	t.Helper()
+	select {
+	case got := <-advanced:
+		t.Fatalf("advanced to %+v", got)
+	case <-time.After(wait):
+	}
+}
+
+func TestAutoAdvance(t *testing.T) {
+	rm, advanced := testRoom(t)
+	rm.EnqueueTracks(testTracks(2, 100*time.Millisecond), "host", false)
+
+	rm.Next()
+	// Seeking and pausing re-arm the timer; the old one must not fire too
+	rm.Seek(50 * time.Millisecond)
+	rm.Pause()
+	rm.Resume()
+
+	expectAdvance(t, advanced, nowPlaying{"t1", StatePlaying})
+	expectAdvance(t, advanced, nowPlaying{"", StateStopped})
+	expectNoAdvance(t, advanced, 200*time.Millisecond)
+}
+
+func TestStaleAdvance(t *testing.T) {
+	rm, advanced := testRoom(t)
+	rm.EnqueueTracks(testTracks(4, time.Hour), "host", false)
+	rm.Next()
+
+	ops := []struct {
+		name string
+		op   func()
+	}{
+		{"Next", func() { rm.Next() }},
+		{"Seek", func() { rm.Seek(time.Minute) }},
+		{"Pause", rm.Pause},
+		{"Resume", rm.Resume},
+		{"PlayTrack", func() { rm.PlayTrack(&music.Track{ID: "other", DurationMs: time.Hour.Milliseconds()}) }},
+	}
+	for _, tt := range ops {
+		t.Run(tt.name, func(t *testing.T) {
+			// The timer armed before the change fires late
+			stale := generation(rm)
+			tt.op()
+			before, queue := snapshot(rm), queuedTracks(rm)
+			rm.trackEnded(stale)
+
+			if after := snapshot(rm); after != before {
+				t.Fatalf("a stale timer moved playback from %+v to %+v", before, after)
+			}
+			if after := queuedTracks(rm); !slices.Equal(after, queue) {
+				t.Fatalf("a stale timer changed the queue from %v to %v", queue, after)
+			}
+			expectNoAdvance(t, advanced, 0)
+		})
+	}
+
+	// The timer armed last still advances
+	rm.trackEnded(generation(rm))
+	expectAdvance(t, advanced, nowPlaying{"t2", StatePlaying})
+}
+
+func TestMoveInQueue(t *testing.T) {
+	rm, _ := testRoom(t)
+	items := rm.EnqueueTracks(testTracks(4, time.Hour), "host", false)
+
+	tests := []struct {
+		item  int
+		index int
+		want  []string
+	}{
+		{3, 0, []string{"t3", "t0", "t1", "t2"}},
+		{3, -5, []string{"t3", "t0", "t1", "t2"}},
+		{3, 99, []string{"t0", "t1", "t2", "t3"}},
+		{0, 2, []string{"t1", "t2", "t0", "t3"}},
+		{0, 4, []string{"t1", "t2", "t3", "t0"}},
+	}
+	for _, tt := range tests {
+		if err := rm.MoveInQueue(items[tt.item].ID, tt.index); err != nil {
+			t.Fatal(err)
+		}
+		if got := queuedTracks(rm); !slices.Equal(got, tt.want) {
+			t.Fatalf("after moving t%d to %d, queue = %v, want %v", tt.item, tt.index, got, tt.want)
+		}
+	}
+
+	if err := rm.MoveInQueue("missing", 0); err == nil {
+		t.Fatal("moved an item that isn't queued")
+	}
+}
+
+func TestRemoveFromQueue(t *testing.T) {
+	rm, _ := testRoom(t)
+	items := rm.EnqueueTracks(testTracks(3, time.Hour), "host", false)
+	rm.Next()
+
+	// The current item has left the queue, so it can't be removed from it
+	if err := rm.RemoveFromQueue(items[0].ID); err == nil {
+		t.Fatal("removed the current item")
+	}
+	if got := snapshot(rm); got != (nowPlaying{"t0", StatePlaying}) {
+		t.Fatalf("now playing %+v after removing the current item", got)
+	}
+
+	if err := rm.RemoveFromQueue(items[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := queuedTracks(rm); !slices.Equal(got, []string{"t2"}) {
+		t.Fatalf("queue = %v, want [t2]", got)
+	}
+	if err := rm.RemoveFromQueue(items[1].ID); err == nil {
+		t.Fatal("removed an item twice")
+	}
+}
+
+func TestClearWhilePlaying(t *testing.T) {
+	rm, advanced := testRoom(t)
+	rm.SetRepeat(RepeatAll)
+	rm.EnqueueTracks(testTracks(3, 100*time.Millisecond), "host", false)
+	rm.Next()
+	rm.Next()
+
+	// The current track plays on, but what already played doesn't come
+	// round again
+	rm.ClearQueue()
+	if got := snapshot(rm); got != (nowPlaying{"t1", StatePlaying}) {
+		t.Fatalf("now playing %+v after clearing the queue", got)
+	}
+	if got := queuedTracks(rm); len(got) != 0 {
+		t.Fatalf("queue = %v after clearing it", got)
+	}
+
+	expectAdvance(t, advanced, nowPlaying{"t1", StatePlaying})
+	if got := queuedTracks(rm); len(got) != 0 {
+		t.Fatalf("queue = %v, want only the current track repeating", got)
+	}
+
+	rm.SetRepeat(RepeatOff)
+	expectAdvance(t, advanced, nowPlaying{"", StateStopped})
+}
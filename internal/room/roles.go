@@ -0,0 +1,136 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Capability is something a member of a room may be allowed to do. Roles
+// bundle them up.
+type Capability string
+
+const (
+	CapPlay     Capability = "play"
+	CapPause    Capability = "pause" // pause and resume
+	CapSeek     Capability = "seek"
+	CapSkip     Capability = "skip"
+	CapEnqueue  Capability = "enqueue"
+	CapReorder  Capability = "reorder_queue" // move, remove and clear queued tracks
+	CapKick     Capability = "kick"
+	CapSettings Capability = "change_settings" // repeat and shuffle
+)
+
+var (
+	ErrNotMember = errors.New("user is not in the room")
+	ErrHostRole  = errors.New("the host's role can't change")
+	ErrKickHost  = errors.New("the host can't be kicked")
+	ErrOutranked = errors.New("members can only kick those with a lower role")
+)
+
+var roleCapabilities = map[UserRole][]Capability{
+	RoleHost:      {CapPlay, CapPause, CapSeek, CapSkip, CapEnqueue, CapReorder, CapKick, CapSettings},
+	RoleCoHost:    {CapPlay, CapPause, CapSeek, CapSkip, CapEnqueue, CapReorder, CapKick, CapSettings},
+	RoleDJ:        {CapPlay, CapPause, CapSeek, CapSkip, CapEnqueue, CapReorder},
+	RoleModerator: {CapReorder, CapKick},
+	RoleListener:  {},
+}
+
+// roleRanks orders roles by standing in the room, for deciding who may
+// kick whom.
+var roleRanks = map[UserRole]int{
+	RoleHost:      4,
+	RoleCoHost:    3,
+	RoleModerator: 2,
+	RoleDJ:        1,
+	RoleListener:  0,
+}
+
+// Outranks reports whether a role stands above another.
+func (role UserRole) Outranks(other UserRole) bool {
+	return roleRanks[role] > roleRanks[other]
+}
+
+// ParseGrantableRole parses a role the host may give someone. There is only
+// ever one host.
+func ParseGrantableRole(s string) (UserRole, error) {
+	switch role := UserRole(s); role {
+	case RoleCoHost, RoleDJ, RoleModerator, RoleListener:
+		return role, nil
+	}
+	return "", fmt.Errorf("unknown role %q", s)
+}
+
+// Capabilities lists what members with the role may do.
+func (role UserRole) Capabilities() []Capability {
+	return roleCapabilities[role]
+}
+
+func (role UserRole) Can(capability Capability) bool {
+	for _, c := range roleCapabilities[role] {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether a member of the room may do something. Nobody else
+// may do anything.
+func (r *Room) Can(userID string, capability Capability) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, exists := r.Listeners[userID]
+	if !exists {
+		return false
+	}
+	return user.Role.Can(capability)
+}
+
+// SetRole grants a member a new role, or takes their old one away by
+// making them a listener.
+func (r *Room) SetRole(userID string, role UserRole) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, exists := r.Listeners[userID]
+	if !exists {
+		return User{}, ErrNotMember
+	}
+	if userID == r.Host {
+		return User{}, ErrHostRole
+	}
+	user.Role = role
+	if role == RoleListener {
+		delete(r.grants, userID)
+	} else {
+		r.grants[userID] = role
+	}
+	return *user, nil
+}
+
+// Kick has one member remove another from the room straight away. The
+// host can't be kicked, and nobody can kick someone whose role is as high
+// as their own.
+func (r *Room) Kick(byUserID, userID string) (User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, exists := r.Listeners[userID]
+	if !exists {
+		return User{}, ErrNotMember
+	}
+	if userID == r.Host {
+		return User{}, ErrKickHost
+	}
+	by, exists := r.Listeners[byUserID]
+	if !exists || !by.Role.Outranks(user.Role) {
+		return User{}, ErrOutranked
+	}
+	if user.leaveTimer != nil {
+		user.leaveTimer.Stop()
+	}
+	delete(r.Listeners, userID)
+	delete(r.grants, userID)
+	return *user, nil
+}
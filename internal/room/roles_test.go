@@ -0,0 +1,106 @@
+package room
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestKickRespectsRank(t *testing.T) {
+	roles := []UserRole{RoleCoHost, RoleModerator, RoleDJ, RoleListener}
+
+	tests := []struct {
+		by, target UserRole
+		err        error
+	}{
+		{RoleHost, RoleCoHost, nil},
+		{RoleCoHost, RoleModerator, nil},
+		{RoleCoHost, RoleCoHost, ErrOutranked},
+		{RoleModerator, RoleDJ, nil},
+		{RoleModerator, RoleListener, nil},
+		{RoleModerator, RoleModerator, ErrOutranked},
+		{RoleModerator, RoleCoHost, ErrOutranked},
+		{RoleDJ, RoleListener, nil},
+		{RoleListener, RoleListener, ErrOutranked},
+		{RoleCoHost, RoleHost, ErrKickHost},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.by)+" kicks "+string(tt.target), func(t *testing.T) {
+			m := NewManager()
+			m.SetSuccession(Succession{Policy: SucceedOff})
+			rm := m.CreateRoom("room", "Room", "host")
+			defer m.DeleteRoom("room", ClosedByHost)
+
+			// One member of every role besides the host, two of the kicker's
+			ids := map[UserRole]string{RoleHost: "host"}
+			for _, role := range roles {
+				id := string(role)
+				if err := m.JoinRoom("room", id, id); err != nil {
+					t.Fatal(err)
+				}
+				if role != RoleListener {
+					if _, err := rm.SetRole(id, role); err != nil {
+						t.Fatal(err)
+					}
+				}
+				ids[role] = id
+			}
+			target := ids[tt.target]
+			if tt.by == tt.target {
+				target = "other"
+				if err := m.JoinRoom("room", target, target); err != nil {
+					t.Fatal(err)
+				}
+				if tt.target != RoleListener {
+					if _, err := rm.SetRole(target, tt.target); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			_, err := rm.Kick(ids[tt.by], target)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Kick = %v, want %v", err, tt.err)
+			}
+			if _, stillIn := rm.GetUser(target); stillIn != (err != nil) {
+				t.Errorf("target still in the room: %v", stillIn)
+			}
+		})
+	}
+}
+
+func TestRoleOutlastsGrace(t *testing.T) {
+	m := NewManager()
+	m.SetSuccession(Succession{Policy: SucceedOff})
+	rm := m.CreateRoom("room", "Room", "host")
+	defer m.DeleteRoom("room", ClosedByHost)
+
+	if err := m.JoinRoom("room", "dj", "DJ"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rm.SetRole("dj", RoleDJ); err != nil {
+		t.Fatal(err)
+	}
+
+	// Away for longer than the grace period, then back
+	expireGrace(rm, "dj")
+	if _, ok := rm.GetUser("dj"); ok {
+		t.Fatal("member still in the room after the grace period")
+	}
+	if err := m.JoinRoom("room", "dj", "DJ"); err != nil {
+		t.Fatal(err)
+	}
+	if role := rm.GetUserRole("dj"); role != RoleDJ {
+		t.Fatalf("role after rejoining = %s, want %s", role, RoleDJ)
+	}
+
+	// Being kicked takes the role away for good
+	if _, err := rm.Kick("host", "dj"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.JoinRoom("room", "dj", "DJ"); err != nil {
+		t.Fatal(err)
+	}
+	if role := rm.GetUserRole("dj"); role != RoleListener {
+		t.Fatalf("role after being kicked = %s, want %s", role, RoleListener)
+	}
+}
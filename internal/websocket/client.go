@@ -0,0 +1,279 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"synctunes/internal/protocol"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeWait is how long a write may take.
+	writeWait = 10 * time.Second
+	// pongWait is how long a client may stay silent, pongs included,
+	// before it is taken for gone.
+	pongWait = 60 * time.Second
+	// pingPeriod is how often clients are pinged; it must be shorter than
+	// pongWait.
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize is the largest message a client may send. Commands are
+	// tiny, so anything near this is a mistake or abuse.
+	maxMessageSize = 16 * 1024
+	// maxBatch is the most queued messages sent together in one frame.
+	maxBatch = 64
+)
+
+// Client is one websocket connection. Both its room hub and its own read
+// pump queue messages in its outbox; closing done tells the write pump to
+// stop.
+type Client struct {
+	hub         *Hub
+	conn        *websocket.Conn
+	outbox      *outbox
+	done        chan struct{}
+	closeOnce   sync.Once
+	closeCode   int // sent in the close frame, when set
+	closeReason string
+	closeFlush  bool // send what is still queued before the close frame
+	userID      string
+	roomID      string
+	role        string // the member's role in the room; belongs to the room hub
+	version     int    // negotiated protocol version
+	resumeFrom  uint64 // last event seen before reconnecting, or 0
+}
+
+func newClient(hub *Hub, conn *websocket.Conn, roomID, userID, role string) *Client {
+	return &Client{
+		hub:    hub,
+		conn:   conn,
+		outbox: newOutbox(hub.policy, &hub.counters),
+		done:   make(chan struct{}),
+		userID: userID,
+		roomID: roomID,
+		role:   role,
+	}
+}
+
+// queue adds a message to the client's outbox. A client too far behind to
+// take it is disconnected.
+func (c *Client) queue(msgType string, message []byte) bool {
+	if c.outbox.push(msgType, message) {
+		return true
+	}
+	c.disconnect(websocket.CloseTryAgainLater, "too slow to keep up")
+	return false
+}
+
+// close stops the write pump, which closes the connection; nothing else
+// closes it. It is safe to call more than once.
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// disconnect closes the connection with a close frame giving the reason.
+func (c *Client) disconnect(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.done)
+	})
+}
+
+// finish closes the connection like disconnect, once the messages already
+// queued have been sent.
+func (c *Client) finish(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		c.closeFlush = true
+		close(c.done)
+	})
+}
+
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unregister <- c
+		c.close()
+	}()
+
+	// A client that stops answering pings is gone, even if its TCP
+	// connection never says so
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, message, err := c.conn.ReadMessage()
+		received := time.Now()
+		if err != nil {
+			var netErr net.Error
+			switch {
+			case errors.As(err, &netErr) && netErr.Timeout():
+				log.Printf("Evicting unresponsive client %s from room %s", c.userID, c.roomID)
+				c.disconnect(websocket.CloseGoingAway, "ping timeout")
+			case errors.Is(err, websocket.ErrReadLimit):
+				// The connection has already told the client why
+				log.Printf("Client %s sent a message over %d bytes", c.userID, maxMessageSize)
+			case websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure):
+				log.Printf("WebSocket error: %v", err)
+			}
+			break
+		}
+
+		// Handle incoming messages (for future features like chat)
+		var msg protocol.Envelope
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Printf("Error parsing message: %v", err)
+			continue
+		}
+
+		// Process message based on type
+		switch {
+		case msg.Type == protocol.TypePing:
+			c.sendMessage(protocol.TypePong, nil)
+		case msg.Type == protocol.TypeTimeSync:
+			c.handleTimeSync(&msg, received)
+		case msg.Type == protocol.TypePresence:
+			c.handlePresence(&msg)
+		case isCommand(msg.Type):
+			c.handleCommand(&msg)
+		default:
+			c.sendError(&msg, protocol.ErrUnknownType, fmt.Sprintf("unknown message type %q", msg.Type))
+		}
+	}
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case <-c.outbox.wake:
+			pending := c.outbox.take()
+			for len(pending) > 0 {
+				n := min(len(pending), maxBatch)
+				if err := c.write(pending[:n]); err != nil {
+					log.Printf("Error writing to client %s: %v", c.userID, err)
+					return
+				}
+				pending = pending[n:]
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			if c.closeFlush {
+				c.flush()
+			}
+			if c.closeCode != 0 {
+				closeMessage := websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+				c.conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(writeWait))
+			}
+			return
+		}
+	}
+}
+
+// flush sends whatever is left in the outbox, giving up at the first error.
+func (c *Client) flush() {
+	pending := c.outbox.take()
+	for len(pending) > 0 {
+		n := min(len(pending), maxBatch)
+		if err := c.write(pending[:n]); err != nil {
+			return
+		}
+		pending = pending[n:]
+	}
+}
+
+// write sends queued messages in a single frame: a lone message as it is,
+// several wrapped in a batch.
+func (c *Client) write(messages []outgoing) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if len(messages) == 1 {
+		return c.conn.WriteMessage(websocket.TextMessage, messages[0].message)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"v":`)
+	buf.WriteString(strconv.Itoa(c.version))
+	buf.WriteString(`,"type":"` + protocol.TypeBatch + `","data":[`)
+	for i, m := range messages {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(m.message)
+	}
+	buf.WriteString("]}")
+	return c.conn.WriteMessage(websocket.TextMessage, buf.Bytes())
+}
+
+func (c *Client) handleTimeSync(msg *protocol.Envelope, received time.Time) {
+	var sync protocol.TimeSync
+	if err := msg.Decode(&sync); err != nil {
+		log.Printf("Error parsing time sync: %v", err)
+		return
+	}
+	sync.ServerReceive = unixMillis(received)
+	sync.ServerSend = unixMillis(time.Now())
+
+	c.sendMessage(protocol.TypeTimeSync, sync)
+}
+
+// unixMillis returns t as fractional Unix milliseconds.
+func unixMillis(t time.Time) float64 {
+	return float64(t.UnixMicro()) / 1000
+}
+
+// sendMessage sends a message to this client alone.
+func (c *Client) sendMessage(msgType string, data interface{}) {
+	env, err := protocol.NewEnvelope(msgType, data)
+	if err != nil {
+		log.Printf("Error encoding %s: %v", msgType, err)
+		return
+	}
+	c.sendEnvelope(env)
+}
+
+// sendError tells this client why its request failed.
+func (c *Client) sendError(request *protocol.Envelope, code, message string) {
+	reply, err := protocol.NewEnvelope(protocol.TypeError, protocol.Error{
+		Code:    code,
+		Message: message,
+	})
+	if err != nil {
+		log.Printf("Error encoding error reply: %v", err)
+		return
+	}
+	reply.RequestID = request.RequestID
+	c.sendEnvelope(reply)
+}
+
+func (c *Client) sendEnvelope(env *protocol.Envelope) {
+	env.V = c.version
+	message, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("Error encoding %s: %v", env.Type, err)
+		return
+	}
+	c.queue(env.Type, message)
+}
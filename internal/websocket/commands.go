@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"synctunes/internal/protocol"
+	"synctunes/internal/room"
+)
+
+// commandCapabilities says what a member must be allowed to do to send
+// each command.
+var commandCapabilities = map[string]room.Capability{
+	protocol.TypePlay:    room.CapPlay,
+	protocol.TypePause:   room.CapPause,
+	protocol.TypeResume:  room.CapPause,
+	protocol.TypeSeek:    room.CapSeek,
+	protocol.TypeNext:    room.CapSkip,
+	protocol.TypeEnqueue: room.CapEnqueue,
+}
+
+func isCommand(messageType string) bool {
+	_, ok := commandCapabilities[messageType]
+	return ok
+}
+
+// handleCommand runs a command and replies with an ack, or an error
+// explaining why it failed. The request ID is echoed so clients can match
+// replies to requests.
+func (c *Client) handleCommand(cmd *protocol.Envelope) {
+	var reply *protocol.Envelope
+	var err error
+	if failure := c.hub.runCommand(c, cmd); failure != nil {
+		reply, err = protocol.NewEnvelope(protocol.TypeError, failure)
+	} else {
+		reply, err = protocol.NewEnvelope(protocol.TypeAck, nil)
+	}
+	if err != nil {
+		log.Printf("Error encoding command reply: %v", err)
+		return
+	}
+	reply.RequestID = cmd.RequestID
+	c.sendEnvelope(reply)
+}
+
+// runCommand applies a command to the client's room and broadcasts the
+// result. The room is asked every time, so a member's new role applies to
+// their very next command.
+func (h *Hub) runCommand(c *Client, cmd *protocol.Envelope) *protocol.Error {
+	rm, exists := h.roomManager.GetRoom(c.roomID)
+	if !exists {
+		return &protocol.Error{Code: protocol.ErrNotFound, Message: "room not found"}
+	}
+	if !rm.Can(c.userID, commandCapabilities[cmd.Type]) {
+		return &protocol.Error{Code: protocol.ErrForbidden, Message: "insufficient permissions"}
+	}
+
+	invalid := &protocol.Error{
+		Code:    protocol.ErrBadRequest,
+		Message: fmt.Sprintf("invalid %s command", cmd.Type),
+	}
+
+	switch cmd.Type {
+	case protocol.TypePlay:
+		var args protocol.PlayCommand
+		if err := cmd.Decode(&args); err != nil {
+			return invalid
+		}
+		track, err := h.musicService.GetTrack(args.TrackID)
+		if err != nil {
+			return &protocol.Error{Code: protocol.ErrNotFound, Message: err.Error()}
+		}
+		rm.PlayTrack(track)
+		h.BroadcastTrackChanged(rm)
+	case protocol.TypePause:
+		rm.Pause()
+		h.BroadcastPlayback(rm)
+	case protocol.TypeResume:
+		rm.Resume()
+		h.BroadcastPlayback(rm)
+	case protocol.TypeSeek:
+		var args protocol.SeekCommand
+		if err := cmd.Decode(&args); err != nil {
+			return invalid
+		}
+		rm.Seek(time.Duration(args.Position * float64(time.Second)))
+		h.BroadcastPlayback(rm)
+	case protocol.TypeNext:
+		rm.Next()
+		h.BroadcastTrackChanged(rm)
+		h.BroadcastQueue(rm.ID, rm.GetQueue())
+	case protocol.TypeEnqueue:
+		var args protocol.EnqueueCommand
+		if err := cmd.Decode(&args); err != nil {
+			return invalid
+		}
+		if args.AlbumID != "" {
+			album, err := h.musicService.GetAlbum(args.AlbumID)
+			if err != nil {
+				return &protocol.Error{Code: protocol.ErrNotFound, Message: err.Error()}
+			}
+			rm.EnqueueTracks(album.Tracks, c.userID, args.Next)
+			h.BroadcastQueue(rm.ID, rm.GetQueue())
+			break
+		}
+		track, err := h.musicService.GetTrack(args.TrackID)
+		if err != nil {
+			return &protocol.Error{Code: protocol.ErrNotFound, Message: err.Error()}
+		}
+		if args.Next {
+			rm.InsertNext(track, c.userID)
+		} else {
+			rm.Enqueue(track, c.userID)
+		}
+		h.BroadcastQueue(rm.ID, rm.GetQueue())
+	default:
+		return &protocol.Error{Code: protocol.ErrUnknownType, Message: fmt.Sprintf("unknown command %q", cmd.Type)}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+
+	"synctunes/internal/protocol"
+)
+
+// historySize is how many recent events a room keeps for clients that
+// reconnect. It is under the client outbox limit, so a full replay always
+// fits.
+const historySize = 128
+
+// eventLog keeps the most recent events broadcast to a room, so clients
+// that reconnect can catch up on what they missed. It lasts as long as the
+// room does, across room hubs, so sequence numbers never restart.
+type eventLog struct {
+	events []outgoing // ring buffer, indexed by seq
+	last   uint64     // seq of the newest event
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{events: make([]outgoing, historySize)}
+}
+
+// record numbers an event as the next in the log, and adds it encoded.
+// It returns the encoded event, or nil if it couldn't be encoded.
+func (l *eventLog) record(env *protocol.Envelope) []byte {
+	env.Seq = l.nextSeq()
+	message, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("Error encoding %s: %v", env.Type, err)
+		return nil
+	}
+	l.add(env.Type, message)
+	return message
+}
+
+// nextSeq is the sequence number the next event will have.
+func (l *eventLog) nextSeq() uint64 {
+	return l.last + 1
+}
+
+// add records the encoded event numbered nextSeq.
+func (l *eventLog) add(msgType string, message []byte) {
+	l.last++
+	l.events[l.last%historySize] = outgoing{msgType: msgType, message: message}
+}
+
+// since returns the events after seq, oldest first. It returns false when
+// some of them have already been dropped, or seq is from before the log
+// started, in which case the client needs a full snapshot.
+func (l *eventLog) since(seq uint64) ([]outgoing, bool) {
+	if seq > l.last || l.last-seq > historySize {
+		return nil, false
+	}
+
+	missed := make([]outgoing, 0, l.last-seq)
+	for s := seq + 1; s <= l.last; s++ {
+		missed = append(missed, l.events[s%historySize])
+	}
+	return missed, true
+}
@@ -4,65 +4,116 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"synctunes/internal/music"
+	"synctunes/internal/protocol"
 	"synctunes/internal/room"
 
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for now
-	},
+// Hub routes messages to the room hubs. Each map has a single owner: the
+// rooms map belongs to the Run goroutine and each room's clients to that
+// room's goroutine, so everything else talks to them over channels.
+type Hub struct {
+	rooms        map[string]*RoomHub
+	logs         map[string]*eventLog // each room's events; lent to its room hub while it runs
+	roomManager  *room.Manager
+	musicService *music.Service
+	register     chan *Client
+	unregister   chan *Client
+	deliveries   chan delivery
+	policy       SlowClientPolicy
+	counters     backpressureCounters
+	upgrader     websocket.Upgrader
+	origins      map[string]bool // other sites' pages allowed to connect
 }
 
-type Hub struct {
-	rooms      map[string]*RoomHub
-	roomManager *room.Manager
-	register   chan *Client
-	unregister chan *Client
+// Stats reports what the hub's slow client policy has done so far.
+type Stats struct {
+	SlowClientPolicy SlowClientPolicy `json:"slow_client_policy"`
+	Coalesced        uint64           `json:"coalesced"`
+	Dropped          uint64           `json:"dropped"`
+	Disconnected     uint64           `json:"disconnected"`
 }
 
-type RoomHub struct {
-	roomID     string
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
+// delivery is a message on its way to the clients of a room, or of every
+// room when roomID is empty.
+type delivery struct {
+	roomID  string
+	event   *protocol.Envelope // a room event, numbered and kept for replay
+	message []byte             // otherwise, a message sent as it is
+	msgType string             // the type of message, for the slow client policy
+	role    string             // only to clients with this role, when set
+	can     room.Capability    // only to clients whose role has this capability, when set
+	member  *memberChange      // applied to a member's clients before the event goes out
+	closing bool               // the room is gone: its clients are disconnected after the event and its hub stops
 }
 
-type Client struct {
-	hub    *RoomHub
-	conn   *websocket.Conn
-	send   chan []byte
-	userID string
-	roomID string
-	role   string // "host" or "listener"
+// memberChange updates the clients of a member whose standing in the room
+// changed.
+type memberChange struct {
+	userID  string
+	role    string // their new role
+	removed bool   // they were kicked out, and get disconnected
 }
 
-type Message struct {
-	Type string      `json:"type"`
-	Data interface{} `json:"data"`
+func NewHub(roomManager *room.Manager, musicService *music.Service) *Hub {
+	h := &Hub{
+		rooms:        make(map[string]*RoomHub),
+		logs:         make(map[string]*eventLog),
+		roomManager:  roomManager,
+		musicService: musicService,
+		register:     make(chan *Client),
+		unregister:   make(chan *Client),
+		deliveries:   make(chan delivery, 256),
+		policy:       PolicyCoalesce,
+	}
+	h.upgrader.CheckOrigin = h.checkOrigin
+	return h
 }
 
-type RoomUpdateMessage struct {
-	Type string      `json:"type"`
-	Room interface{} `json:"room"`
+// SetAllowedOrigins lets pages from other origins, such as
+// "https://example.com", connect. Session cookies authorise commands, so
+// otherwise only the server's own pages may. It must be called before the
+// hub accepts connections.
+func (h *Hub) SetAllowedOrigins(origins []string) {
+	h.origins = make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		h.origins[strings.TrimSuffix(origin, "/")] = true
+	}
+}
+
+// checkOrigin accepts clients that aren't browsers, which send no Origin,
+// pages served from the same host and the allowed origins.
+func (h *Hub) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host) || h.origins[origin]
 }
 
-type UserJoinMessage struct {
-	Type     string `json:"type"`
-	UserID   string `json:"user_id"`
-	UserName string `json:"user_name"`
-	Role     string `json:"role"`
+// SetSlowClientPolicy chooses what happens to clients that fall behind.
+// It must be called before the hub accepts connections.
+func (h *Hub) SetSlowClientPolicy(policy SlowClientPolicy) {
+	h.policy = policy
 }
 
-func NewHub(roomManager *room.Manager) *Hub {
-	return &Hub{
-		rooms:       make(map[string]*RoomHub),
-		roomManager: roomManager,
-		register:    make(chan *Client),
-		unregister:  make(chan *Client),
+func (h *Hub) Stats() Stats {
+	return Stats{
+		SlowClientPolicy: h.policy,
+		Coalesced:        h.counters.coalesced.Load(),
+		Dropped:          h.counters.dropped.Load(),
+		Disconnected:     h.counters.disconnected.Load(),
 	}
 }
 
@@ -73,6 +124,8 @@ func (h *Hub) Run() {
 			h.handleRegister(client)
 		case client := <-h.unregister:
 			h.handleUnregister(client)
+		case d := <-h.deliveries:
+			h.route(d)
 		}
 	}
 }
@@ -80,155 +133,291 @@ func (h *Hub) Run() {
 func (h *Hub) handleRegister(client *Client) {
 	roomHub, exists := h.rooms[client.roomID]
 	if !exists {
-		roomHub = &RoomHub{
-			roomID:     client.roomID,
-			clients:    make(map[*Client]bool),
-			broadcast:  make(chan []byte, 256),
-			register:   make(chan *Client),
-			unregister: make(chan *Client),
+		// The room may have been closed while the client connected
+		events := h.roomLog(client.roomID)
+		if events == nil {
+			client.disconnect(websocket.CloseNormalClosure, "room closed")
+			return
 		}
+		roomHub = newRoomHub(client.roomID, h.roomManager, events)
 		h.rooms[client.roomID] = roomHub
 		go roomHub.run()
 	}
-	
+
+	roomHub.members++
 	roomHub.register <- client
 }
 
+// handleUnregister passes a departing client to its room hub, and shuts
+// the room hub down once nobody is left.
 func (h *Hub) handleUnregister(client *Client) {
-	if roomHub, exists := h.rooms[client.roomID]; exists {
-		roomHub.unregister <- client
+	roomHub, exists := h.rooms[client.roomID]
+	if !exists {
+		return
 	}
-}
 
-func (h *Hub) BroadcastToRoom(roomID string, message []byte) {
-	if roomHub, exists := h.rooms[roomID]; exists {
-		select {
-		case roomHub.broadcast <- message:
-		default:
-			// Room hub is full, skip message
-		}
+	roomHub.unregister <- client
+	roomHub.members--
+	if roomHub.members == 0 {
+		delete(h.rooms, client.roomID)
+		close(roomHub.done)
+		// Wait for it to let go of the event log, which the next room hub
+		// carries on from
+		<-roomHub.stopped
+		log.Printf("Closed hub for room %s", client.roomID)
 	}
 }
 
-func (h *Hub) BroadcastToHosts(roomID string, message []byte) {
-	if roomHub, exists := h.rooms[roomID]; exists {
-		for client := range roomHub.clients {
-			if client.role == "host" {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(roomHub.clients, client)
-				}
+func (h *Hub) route(d delivery) {
+	if d.roomID == "" {
+		for _, roomHub := range h.rooms {
+			roomHub.deliveries <- d
+		}
+		return
+	}
+	// The room is gone for good after this, and so is its event log
+	if d.closing {
+		delete(h.logs, d.roomID)
+	}
+	roomHub, exists := h.rooms[d.roomID]
+	if !exists {
+		// Nobody is connected, but whoever resumes later still needs to
+		// hear about room events
+		if d.event != nil && !d.closing {
+			if events := h.roomLog(d.roomID); events != nil {
+				events.record(d.event)
 			}
 		}
+		return
+	}
+	// The room hub stops once it has handled this, so nothing more may be
+	// sent to it; its clients' unregisters are ignored
+	if d.closing {
+		delete(h.rooms, d.roomID)
 	}
+	roomHub.deliveries <- d
+}
+
+// roomLog returns a room's event log, starting one if it has none yet, or
+// nil if the room doesn't exist.
+func (h *Hub) roomLog(roomID string) *eventLog {
+	if events, exists := h.logs[roomID]; exists {
+		return events
+	}
+	if _, exists := h.roomManager.GetRoom(roomID); !exists {
+		return nil
+	}
+	events := newEventLog()
+	h.logs[roomID] = events
+	return events
+}
+
+// BroadcastToRoom sends an event to everyone in a room. The room hub
+// numbers it in sequence with the room's other events.
+func (h *Hub) BroadcastToRoom(roomID string, env *protocol.Envelope) {
+	h.deliveries <- delivery{roomID: roomID, event: env}
+}
+
+func (h *Hub) BroadcastToHosts(roomID string, message []byte) {
+	h.deliveries <- delivery{roomID: roomID, message: message, role: "host"}
 }
 
 func (h *Hub) BroadcastToListeners(roomID string, message []byte) {
-	if roomHub, exists := h.rooms[roomID]; exists {
-		for client := range roomHub.clients {
-			if client.role == "listener" {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(roomHub.clients, client)
-				}
-			}
-		}
+	h.deliveries <- delivery{roomID: roomID, message: message, role: "listener"}
+}
+
+// BroadcastToAllHosts sends message to the hosts of every active room.
+func (h *Hub) BroadcastToAllHosts(message []byte) {
+	h.BroadcastToHosts("", message)
+}
+
+// BroadcastCatalogChange tells everyone who may queue tracks that the
+// music catalog changed, so their track lists can update without a page
+// refresh and they don't queue tracks that are gone.
+func (h *Hub) BroadcastCatalogChange(change music.CatalogChange) {
+	env, err := protocol.NewEnvelope(protocol.TypeCatalogChanged, protocol.CatalogChanged(change))
+	if err != nil {
+		log.Printf("Error encoding catalog change: %v", err)
+		return
+	}
+	message, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("Error encoding catalog change: %v", err)
+		return
 	}
+	h.deliveries <- delivery{message: message, msgType: protocol.TypeCatalogChanged, can: room.CapEnqueue}
 }
 
-func (rh *RoomHub) run() {
-	for {
-		select {
-		case client := <-rh.register:
-			rh.clients[client] = true
-			log.Printf("Client connected to room %s as %s", rh.roomID, client.role)
-			
-		case client := <-rh.unregister:
-			if _, ok := rh.clients[client]; ok {
-				delete(rh.clients, client)
-				close(client.send)
-				log.Printf("Client (%s) disconnected from room %s", client.role, rh.roomID)
-			}
-			
-		case message := <-rh.broadcast:
-			for client := range rh.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(rh.clients, client)
-				}
-			}
-		}
+// publish wraps data in an envelope and broadcasts it to a room.
+func (h *Hub) publish(roomID, msgType string, data interface{}) {
+	env, err := protocol.NewEnvelope(msgType, data)
+	if err != nil {
+		log.Printf("Error encoding %s: %v", msgType, err)
+		return
 	}
+	h.BroadcastToRoom(roomID, env)
+}
+
+// BroadcastRoomState sends a full snapshot of a room to everyone in it.
+func (h *Hub) BroadcastRoomState(rm *room.Room) {
+	h.publish(rm.ID, protocol.TypeRoomState, protocol.RoomState(rm.GetState()))
+}
+
+// BroadcastTrackChanged announces the room's current track.
+func (h *Hub) BroadcastTrackChanged(rm *room.Room) {
+	track, playback := rm.NowPlaying()
+	h.publish(rm.ID, protocol.TypeTrackChanged, protocol.TrackChanged{
+		Track:    track,
+		Playback: playback,
+	})
+}
+
+// BroadcastPlayback announces a pause, resume or seek.
+func (h *Hub) BroadcastPlayback(rm *room.Room) {
+	h.publish(rm.ID, protocol.TypePlayback, rm.Playback())
+}
+
+// BroadcastQueue sends a room's updated play queue to everyone in it.
+func (h *Hub) BroadcastQueue(roomID string, queue []room.QueueItem) {
+	h.publish(roomID, protocol.TypeQueueChanged, protocol.QueueChanged{Queue: queue})
+}
+
+// BroadcastUserJoined announces a new member of a room.
+func (h *Hub) BroadcastUserJoined(roomID string, user protocol.UserEvent) {
+	h.publish(roomID, protocol.TypeUserJoined, user)
+}
+
+// BroadcastUserLeft announces that someone left a room.
+func (h *Hub) BroadcastUserLeft(roomID string, user protocol.UserEvent) {
+	h.publish(roomID, protocol.TypeUserLeft, user)
+}
+
+// BroadcastRoleChanged announces a member's new role. Their connections
+// take it on straight away.
+func (h *Hub) BroadcastRoleChanged(roomID string, user room.User) {
+	env, err := protocol.NewEnvelope(protocol.TypeRoleChanged, protocol.RoleChanged{
+		UserID:       user.ID,
+		Role:         user.Role,
+		Capabilities: user.Role.Capabilities(),
+	})
+	if err != nil {
+		log.Printf("Error encoding role change: %v", err)
+		return
+	}
+	h.deliveries <- delivery{
+		roomID: roomID,
+		event:  env,
+		member: &memberChange{userID: user.ID, role: string(user.Role)},
+	}
+}
+
+// BroadcastHostChanged announces a room's new host. Both the new and the
+// previous host's connections take on their new roles first.
+func (h *Hub) BroadcastHostChanged(roomID string, change room.HostChange) {
+	if change.Previous.ID != "" {
+		h.BroadcastRoleChanged(roomID, change.Previous)
+	}
+	h.BroadcastRoleChanged(roomID, change.Next)
+	h.publish(roomID, protocol.TypeHostChanged, protocol.HostChanged{
+		HostID:         change.Next.ID,
+		HostName:       change.Next.Name,
+		PreviousHostID: change.Previous.ID,
+		Reason:         change.Reason,
+	})
+}
+
+// BroadcastKicked announces that a member was kicked out of a room, and
+// disconnects them.
+func (h *Hub) BroadcastKicked(roomID string, user protocol.UserEvent) {
+	env, err := protocol.NewEnvelope(protocol.TypeUserLeft, user)
+	if err != nil {
+		log.Printf("Error encoding user left: %v", err)
+		return
+	}
+	h.deliveries <- delivery{
+		roomID: roomID,
+		event:  env,
+		member: &memberChange{userID: user.UserID, removed: true},
+	}
+}
+
+// CloseRoom tells everyone in a room that it was closed, disconnects them
+// and shuts its room hub down.
+func (h *Hub) CloseRoom(roomID string, reason room.CloseReason) {
+	env, err := protocol.NewEnvelope(protocol.TypeRoomClosed, protocol.RoomClosed{Reason: reason})
+	if err != nil {
+		log.Printf("Error encoding room closed: %v", err)
+		return
+	}
+	h.deliveries <- delivery{roomID: roomID, event: env, closing: true}
 }
 
 func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request, roomID, userID string) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("WebSocket upgrade error:", err)
 		return
 	}
-	
+
+	version, err := protocol.Negotiate(r.URL.Query().Get("v"))
+	if err != nil {
+		rejectVersion(conn, err)
+		return
+	}
+
+	// A client that reconnects says which event it saw last, so it can be
+	// sent what it missed rather than starting over
+	resumeFrom, _ := strconv.ParseUint(r.URL.Query().Get("resume_from"), 10, 64)
+
 	// Determine user role
-	room, exists := h.roomManager.GetRoom(roomID)
-	var role string = "listener"
-	if exists && room.IsHost(userID) {
-		role = "host"
-	}
-	
-	client := &Client{
-		conn:   conn,
-		send:   make(chan []byte, 256),
-		userID: userID,
-		roomID: roomID,
-		role:   role,
+	role := room.RoleListener
+	if rm, exists := h.roomManager.GetRoom(roomID); exists {
+		role = rm.GetUserRole(userID)
 	}
-	
+
+	client := newClient(h, conn, roomID, userID, string(role))
+	client.version = version
+	client.resumeFrom = resumeFrom
+
+	// Say hello before anything else; the room hub brings the client up to
+	// date once it is registered
+	client.sendMessage(protocol.TypeHello, protocol.Hello{
+		Version:      version,
+		Supported:    supportedVersions(),
+		UserID:       userID,
+		Role:         string(role),
+		Capabilities: role.Capabilities(),
+	})
+
 	h.register <- client
-	
+
 	go client.writePump()
-	go client.readPump(h)
+	go client.readPump()
 }
 
-func (c *Client) readPump(hub *Hub) {
-	defer func() {
-		hub.unregister <- c
-		c.conn.Close()
-	}()
-	
-	for {
-		_, message, err := c.conn.ReadMessage()
-		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
-			}
-			break
-		}
-		
-		// Handle incoming messages (for future features like chat)
-		var msg Message
-		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Printf("Error parsing message: %v", err)
-			continue
-		}
-		
-		// Process message based on type
-		switch msg.Type {
-		case "ping":
-			c.send <- []byte(`{"type":"pong"}`)
-		}
+// rejectVersion tells a client that none of its protocol versions are
+// supported, and closes the connection.
+func rejectVersion(conn *websocket.Conn, reason error) {
+	defer conn.Close()
+
+	env, err := protocol.NewEnvelope(protocol.TypeError, protocol.Error{
+		Code:    protocol.ErrUnsupportedVersion,
+		Message: reason.Error(),
+	})
+	if err != nil {
+		return
+	}
+	if message, err := json.Marshal(env); err == nil {
+		conn.WriteMessage(websocket.TextMessage, message)
 	}
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseProtocolError, protocol.ErrUnsupportedVersion)
+	conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
 }
 
-func (c *Client) writePump() {
-	defer c.conn.Close()
-	
-	for message := range c.send {
-		c.conn.WriteMessage(websocket.TextMessage, message)
+func supportedVersions() []int {
+	versions := make([]int, 0, protocol.Version-protocol.MinVersion+1)
+	for v := protocol.MinVersion; v <= protocol.Version; v++ {
+		versions = append(versions, v)
 	}
+	return versions
 }
@@ -0,0 +1,241 @@
+package websocket
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"synctunes/internal/music"
+	"synctunes/internal/protocol"
+	"synctunes/internal/room"
+)
+
+type churnRoom struct {
+	room   *room.Room
+	hostID string
+}
+
+func newChurnRoom(manager *room.Manager, i int) *churnRoom {
+	hostID := uuid.New().String()
+	return &churnRoom{
+		room:   manager.CreateRoom(uuid.New().String(), fmt.Sprintf("room %d", i), hostID),
+		hostID: hostID,
+	}
+}
+
+// TestHubChurn churns clients through a hub while broadcasting to their
+// rooms and closing some of them, to shake out races and leaks; run it
+// with -race. Once every client has gone, no room hub or client pump may
+// be left behind.
+func TestHubChurn(t *testing.T) {
+	duration, clients := 3*time.Second, 64
+	if testing.Short() {
+		duration, clients = 500*time.Millisecond, 16
+	}
+	const (
+		roomCount    = 8
+		broadcasters = 4
+		closeEvery   = 100 * time.Millisecond
+	)
+
+	// The hub logs every connect and disconnect
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	manager := room.NewManager()
+	hub := NewHub(manager, nil)
+	manager.OnClose(func(rm *room.Room, reason room.CloseReason) {
+		hub.CloseRoom(rm.ID, reason)
+	})
+	go hub.Run()
+
+	rooms := make([]atomic.Pointer[churnRoom], roomCount)
+	for i := range rooms {
+		rooms[i].Store(newChurnRoom(manager, i))
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		roomID := strings.TrimPrefix(r.URL.Path, "/ws/")
+		hub.HandleWebSocket(w, r, roomID, r.URL.Query().Get("user_id"))
+	}))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/"
+
+	// Let the server settle before measuring the goroutine baseline
+	time.Sleep(100 * time.Millisecond)
+	baseline := runtime.NumGoroutine()
+
+	var connects, broadcasts, closes atomic.Int64
+	deadline := time.Now().Add(duration)
+	var wg sync.WaitGroup
+
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for time.Now().Before(deadline) {
+				if churn(t, rng, wsURL, *rooms[rng.Intn(len(rooms))].Load()) {
+					connects.Add(1)
+				}
+			}
+		}(int64(i))
+	}
+
+	for i := 0; i < broadcasters; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for time.Now().Before(deadline) {
+				broadcast(rng, hub, *rooms[rng.Intn(len(rooms))].Load())
+				broadcasts.Add(1)
+			}
+		}(int64(1000 + i))
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		rng := rand.New(rand.NewSource(2000))
+		for time.Now().Before(deadline) {
+			time.Sleep(closeEvery)
+			i := rng.Intn(len(rooms))
+			old := rooms[i].Swap(newChurnRoom(manager, i))
+			manager.DeleteRoom(old.room.ID, room.ClosedByHost)
+			closes.Add(1)
+		}
+	}()
+
+	wg.Wait()
+	t.Logf("%d connections, %d broadcasts, %d rooms closed", connects.Load(), broadcasts.Load(), closes.Load())
+	if connects.Load() == 0 {
+		t.Fatal("no client connected")
+	}
+
+	// Every client is gone, so every room hub should have shut down too
+	leaked := 0
+	for wait := time.Now().Add(5 * time.Second); time.Now().Before(wait); time.Sleep(100 * time.Millisecond) {
+		if leaked = runtime.NumGoroutine() - baseline; leaked <= 0 {
+			return
+		}
+	}
+	t.Errorf("%d goroutines leaked", leaked)
+}
+
+// churn connects one client, has it talk for a moment, and disconnects it,
+// sometimes cleanly and sometimes not. It reports whether it connected.
+func churn(t *testing.T, rng *rand.Rand, wsURL string, cr churnRoom) bool {
+	userID := uuid.New().String()
+	if rng.Intn(4) == 0 {
+		userID = cr.hostID
+	}
+	url := fmt.Sprintf("%s%s?v=%d&user_id=%s", wsURL, cr.room.ID, protocol.Version, userID)
+	if rng.Intn(3) == 0 {
+		url += fmt.Sprintf("&resume_from=%d", rng.Intn(200))
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Errorf("dial: %v", err)
+		return false
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for n := rng.Intn(5); n > 0; n-- {
+		var message string
+		switch rng.Intn(4) {
+		case 0:
+			message = `{"v":1,"type":"ping"}`
+		case 1:
+			message = `{"v":1,"type":"time_sync","data":{"t0":1}}`
+		case 2:
+			message = `{"v":1,"type":"pause","request_id":"p"}`
+		default:
+			message = `{"v":1,"type":"seek","request_id":"s","data":{"position":1}}`
+		}
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+			break
+		}
+	}
+	time.Sleep(time.Duration(rng.Intn(20)) * time.Millisecond)
+
+	if rng.Intn(2) == 0 {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	}
+	conn.Close()
+	<-done
+	return true
+}
+
+func broadcast(rng *rand.Rand, hub *Hub, cr churnRoom) {
+	switch rng.Intn(6) {
+	case 0:
+		hub.BroadcastRoomState(cr.room)
+	case 1:
+		hub.BroadcastPlayback(cr.room)
+	case 2:
+		hub.BroadcastQueue(cr.room.ID, cr.room.GetQueue())
+	case 3:
+		hub.BroadcastToHosts(cr.room.ID, []byte(`{"v":1,"type":"pong"}`))
+	case 4:
+		hub.BroadcastToListeners(cr.room.ID, []byte(`{"v":1,"type":"pong"}`))
+	default:
+		hub.BroadcastCatalogChange(music.CatalogChange{})
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	hub := NewHub(room.NewManager(), nil)
+	hub.SetAllowedOrigins([]string{"https://friends.example/"})
+
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"http://synctunes.example:8080", true},
+		{"https://SyncTunes.example:8080", true},
+		{"https://friends.example", true},
+		{"https://evil.example", false},
+		{"https://synctunes.example", false},
+		{"https://synctunes.example:8080.evil.example", false},
+		{"https://friends.example.evil.example", false},
+		{"null", false},
+		{"%zz", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://synctunes.example:8080/ws/room", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if ok := hub.checkOrigin(r); ok != tt.ok {
+				t.Fatalf("checkOrigin = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
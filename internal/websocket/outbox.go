@@ -0,0 +1,171 @@
+package websocket
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"synctunes/internal/protocol"
+)
+
+// outboxLimit is how many messages may wait for a client before its slow
+// client policy kicks in.
+const outboxLimit = 256
+
+// SlowClientPolicy decides what happens when a client falls behind and
+// its outbox fills up. Whatever the policy, critical messages such as acks
+// and joins are never dropped: a client that can't take those is
+// disconnected.
+type SlowClientPolicy string
+
+const (
+	// PolicyCoalesce replaces pending state updates with newer ones as
+	// they arrive, so a slow client only receives the latest state, and
+	// drops the oldest other messages if it still falls behind.
+	PolicyCoalesce SlowClientPolicy = "coalesce"
+	// PolicyDropOldest drops the oldest messages that aren't critical.
+	PolicyDropOldest SlowClientPolicy = "drop-oldest"
+	// PolicyDisconnect closes the connection, saying why.
+	PolicyDisconnect SlowClientPolicy = "disconnect"
+)
+
+func ParseSlowClientPolicy(s string) (SlowClientPolicy, error) {
+	switch policy := SlowClientPolicy(s); policy {
+	case PolicyCoalesce, PolicyDropOldest, PolicyDisconnect:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown slow client policy %q", s)
+}
+
+// coalesces reports whether a message is a state update that a newer one
+// of the same type makes redundant.
+func coalesces(msgType string) bool {
+	switch msgType {
+	case protocol.TypeRoomState, protocol.TypeTrackChanged, protocol.TypePlayback, protocol.TypeQueueChanged:
+		return true
+	}
+	return false
+}
+
+// supersedes reports whether a newer message makes an older pending one
+// redundant. A room snapshot covers every other state update.
+func supersedes(newer, older string) bool {
+	if !coalesces(newer) || !coalesces(older) {
+		return false
+	}
+	return newer == older || newer == protocol.TypeRoomState
+}
+
+// critical reports whether a message must reach the client for it to
+// stay consistent.
+func critical(msgType string) bool {
+	switch msgType {
+	case protocol.TypeHello, protocol.TypeUserJoined, protocol.TypeUserLeft, protocol.TypePresence, protocol.TypeRoleChanged, protocol.TypeHostChanged, protocol.TypeRoomClosed, protocol.TypeAck, protocol.TypeError:
+		return true
+	}
+	return false
+}
+
+// backpressureCounters count what slow client policies did, across all
+// clients.
+type backpressureCounters struct {
+	coalesced    atomic.Uint64
+	dropped      atomic.Uint64
+	disconnected atomic.Uint64
+}
+
+type outgoing struct {
+	msgType string
+	message []byte
+}
+
+// outbox holds the messages waiting for a client's write pump. Unlike a
+// channel it can be reordered and trimmed when the client falls behind.
+type outbox struct {
+	mu       sync.Mutex
+	pending  []outgoing
+	policy   SlowClientPolicy
+	counters *backpressureCounters
+	wake     chan struct{} // signalled when messages are added
+}
+
+func newOutbox(policy SlowClientPolicy, counters *backpressureCounters) *outbox {
+	return &outbox{
+		policy:   policy,
+		counters: counters,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// push adds a message for the client. It returns false when the client
+// can't keep up and should be disconnected.
+func (o *outbox) push(msgType string, message []byte) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.policy == PolicyCoalesce && coalesces(msgType) {
+		o.coalesce(msgType)
+	}
+
+	if len(o.pending) >= outboxLimit {
+		switch {
+		case o.policy == PolicyDisconnect:
+			o.counters.disconnected.Add(1)
+			return false
+		case o.dropOldest():
+		case !critical(msgType):
+			// Everything pending is critical, so drop this instead
+			o.counters.dropped.Add(1)
+			return true
+		default:
+			o.counters.disconnected.Add(1)
+			return false
+		}
+	}
+
+	o.pending = append(o.pending, outgoing{msgType: msgType, message: message})
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// dropOldest drops the oldest message that isn't critical, and reports
+// whether there was one.
+func (o *outbox) dropOldest() bool {
+	for i, m := range o.pending {
+		if !critical(m.msgType) {
+			o.pending = append(o.pending[:i], o.pending[i+1:]...)
+			o.counters.dropped.Add(1)
+			return true
+		}
+	}
+	return false
+}
+
+// coalesce drops the pending state updates a new one of msgType makes
+// redundant. The new one goes at the end, so the client never sees state
+// older than the messages before it.
+func (o *outbox) coalesce(msgType string) {
+	kept := o.pending[:0]
+	for _, m := range o.pending {
+		if supersedes(msgType, m.msgType) {
+			o.counters.coalesced.Add(1)
+			continue
+		}
+		kept = append(kept, m)
+	}
+	clear(o.pending[len(kept):])
+	o.pending = kept
+}
+
+// take removes and returns every pending message, oldest first.
+func (o *outbox) take() []outgoing {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pending := o.pending
+	o.pending = nil
+	return pending
+}
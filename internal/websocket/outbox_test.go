@@ -0,0 +1,182 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+
+	"synctunes/internal/protocol"
+	"synctunes/internal/room"
+)
+
+// testOutbox returns an outbox counting into a hub's stats.
+func testOutbox(policy SlowClientPolicy) (*outbox, *Hub) {
+	hub := NewHub(room.NewManager(), nil)
+	hub.SetSlowClientPolicy(policy)
+	return newOutbox(policy, &hub.counters), hub
+}
+
+// pendingMessages lists what an outbox holds as "type:message".
+func pendingMessages(o *outbox) []string {
+	var pending []string
+	for _, m := range o.take() {
+		pending = append(pending, m.msgType+":"+string(m.message))
+	}
+	return pending
+}
+
+func checkStats(t *testing.T, hub *Hub, want Stats) {
+	t.Helper()
+	if got := hub.Stats(); got != want {
+		t.Fatalf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestOutboxCoalesce(t *testing.T) {
+	o, hub := testOutbox(PolicyCoalesce)
+
+	pushes := []struct{ msgType, message string }{
+		{protocol.TypeRoomState, "old state"},
+		{protocol.TypePlayback, "playback"},
+		{protocol.TypeAck, "ack"},
+		{protocol.TypeQueueChanged, "old queue"},
+		{protocol.TypeCatalogChanged, "catalog"},
+		{protocol.TypeQueueChanged, "queue"},
+	}
+	for _, p := range pushes {
+		if !o.push(p.msgType, []byte(p.message)) {
+			t.Fatalf("push %s disconnected the client", p.msgType)
+		}
+	}
+	// A newer queue replaces the older one, and goes after everything else
+	want := []string{"room_state:old state", "playback:playback", "ack:ack", "catalog_changed:catalog", "queue_changed:queue"}
+	if got := pendingMessages(o); !slices.Equal(got, want) {
+		t.Fatalf("pending = %v, want %v", got, want)
+	}
+	checkStats(t, hub, Stats{SlowClientPolicy: PolicyCoalesce, Coalesced: 1})
+
+	// A room snapshot replaces every other state update
+	for _, p := range pushes {
+		o.push(p.msgType, []byte(p.message))
+	}
+	o.push(protocol.TypeRoomState, []byte("state"))
+	want = []string{"ack:ack", "catalog_changed:catalog", "room_state:state"}
+	if got := pendingMessages(o); !slices.Equal(got, want) {
+		t.Fatalf("pending = %v, want %v", got, want)
+	}
+	checkStats(t, hub, Stats{SlowClientPolicy: PolicyCoalesce, Coalesced: 1 + 1 + 3})
+
+	if got := o.take(); len(got) != 0 {
+		t.Fatalf("take left %d messages behind", len(got))
+	}
+}
+
+func TestOutboxCoalesceAtCapacity(t *testing.T) {
+	o, hub := testOutbox(PolicyCoalesce)
+
+	for i := 0; i < outboxLimit; i++ {
+		o.push(protocol.TypeCatalogChanged, []byte(fmt.Sprint(i)))
+	}
+	// Full of messages that don't coalesce, so the oldest goes
+	if !o.push(protocol.TypePlayback, []byte("playback")) {
+		t.Fatal("a full outbox disconnected the client")
+	}
+	pending := pendingMessages(o)
+	if len(pending) != outboxLimit || pending[0] != "catalog_changed:1" || pending[outboxLimit-1] != "playback:playback" {
+		t.Fatalf("pending = %v…%v", pending[0], pending[len(pending)-1])
+	}
+	checkStats(t, hub, Stats{SlowClientPolicy: PolicyCoalesce, Dropped: 1})
+}
+
+func TestOutboxDropOldest(t *testing.T) {
+	o, hub := testOutbox(PolicyDropOldest)
+
+	o.push(protocol.TypeAck, []byte("ack"))
+	for i := 1; i < outboxLimit; i++ {
+		o.push(protocol.TypePlayback, []byte(fmt.Sprint(i)))
+	}
+	checkStats(t, hub, Stats{SlowClientPolicy: PolicyDropOldest})
+
+	// The oldest message is critical, so the one after it goes
+	for i := 0; i < 2; i++ {
+		if !o.push(protocol.TypeRoomState, []byte(fmt.Sprint("state ", i))) {
+			t.Fatal("a full outbox disconnected the client")
+		}
+	}
+	pending := pendingMessages(o)
+	if len(pending) != outboxLimit {
+		t.Fatalf("%d messages pending, want %d", len(pending), outboxLimit)
+	}
+	want := []string{"ack:ack", "playback:3"}
+	if !slices.Equal(pending[:2], want) || pending[outboxLimit-1] != "room_state:state 1" {
+		t.Fatalf("pending = %v…%v, want %v…room_state:state 1", pending[:2], pending[outboxLimit-1], want)
+	}
+	checkStats(t, hub, Stats{SlowClientPolicy: PolicyDropOldest, Dropped: 2})
+}
+
+func TestOutboxAllCritical(t *testing.T) {
+	for _, policy := range []SlowClientPolicy{PolicyCoalesce, PolicyDropOldest} {
+		t.Run(string(policy), func(t *testing.T) {
+			o, hub := testOutbox(policy)
+			for i := 0; i < outboxLimit; i++ {
+				o.push(protocol.TypeUserJoined, []byte(fmt.Sprint(i)))
+			}
+
+			// Nothing pending can go, so a new message that isn't critical
+			// is dropped instead...
+			if !o.push(protocol.TypePlayback, []byte("playback")) {
+				t.Fatal("a message that isn't critical disconnected the client")
+			}
+			checkStats(t, hub, Stats{SlowClientPolicy: policy, Dropped: 1})
+
+			// ...and one that is disconnects the client
+			if o.push(protocol.TypeAck, []byte("ack")) {
+				t.Fatal("a critical message was dropped")
+			}
+			checkStats(t, hub, Stats{SlowClientPolicy: policy, Dropped: 1, Disconnected: 1})
+
+			pending := pendingMessages(o)
+			if len(pending) != outboxLimit || !slices.Contains(pending, "user_joined:0") {
+				t.Fatalf("%d messages pending, want the %d critical ones", len(pending), outboxLimit)
+			}
+		})
+	}
+}
+
+func TestOutboxDisconnect(t *testing.T) {
+	o, hub := testOutbox(PolicyDisconnect)
+
+	for i := 0; i < outboxLimit; i++ {
+		if !o.push(protocol.TypePlayback, []byte(fmt.Sprint(i))) {
+			t.Fatalf("push %d of %d disconnected the client", i+1, outboxLimit)
+		}
+	}
+	// State updates don't coalesce under this policy
+	checkStats(t, hub, Stats{SlowClientPolicy: PolicyDisconnect})
+
+	if o.push(protocol.TypePlayback, []byte("playback")) {
+		t.Fatal("a full outbox took another message")
+	}
+	if o.push(protocol.TypeAck, []byte("ack")) {
+		t.Fatal("a full outbox took another critical message")
+	}
+	checkStats(t, hub, Stats{SlowClientPolicy: PolicyDisconnect, Disconnected: 2})
+}
+
+func TestStatsJSON(t *testing.T) {
+	o, hub := testOutbox(PolicyDropOldest)
+	for i := 0; i <= outboxLimit; i++ {
+		o.push(protocol.TypePlayback, []byte(fmt.Sprint(i)))
+	}
+
+	// As served by /api/ws/status
+	data, err := json.Marshal(hub.Stats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"slow_client_policy":"drop-oldest","coalesced":0,"dropped":1,"disconnected":0}`
+	if string(data) != want {
+		t.Fatalf("stats = %s, want %s", data, want)
+	}
+}
@@ -0,0 +1,74 @@
+package websocket
+
+import (
+	"log"
+
+	"synctunes/internal/protocol"
+	"synctunes/internal/room"
+)
+
+// presenceOf is the presence event for a member's current status.
+func presenceOf(user room.User) protocol.Presence {
+	lastSeen := user.LastSeen
+	return protocol.Presence{
+		UserID:   user.ID,
+		Status:   user.Status,
+		LastSeen: &lastSeen,
+	}
+}
+
+// connectPresence counts a new connection towards its user's presence,
+// and reports whether that brought them online.
+func (rh *RoomHub) connectPresence(client *Client) (room.User, bool) {
+	rm, exists := rh.roomManager.GetRoom(rh.roomID)
+	if !exists {
+		return room.User{}, false
+	}
+	return rm.Connect(client.userID)
+}
+
+// disconnectPresence counts a closed connection, announcing the user as
+// away once their last one is gone. They leave the room for good when the
+// grace period runs out.
+func (rh *RoomHub) disconnectPresence(client *Client) {
+	rm, exists := rh.roomManager.GetRoom(rh.roomID)
+	if !exists {
+		return
+	}
+	if user, changed := rm.Disconnect(client.userID); changed {
+		rh.announcePresence(user)
+	}
+}
+
+func (rh *RoomHub) announcePresence(user room.User) {
+	env, err := protocol.NewEnvelope(protocol.TypePresence, presenceOf(user))
+	if err != nil {
+		log.Printf("Error encoding presence: %v", err)
+		return
+	}
+	rh.dispatch(delivery{event: env})
+}
+
+// handlePresence records the status a client reports for its user, such as
+// idle while its page is hidden, and tells the room if it changed.
+func (c *Client) handlePresence(msg *protocol.Envelope) {
+	var presence protocol.Presence
+	if err := msg.Decode(&presence); err != nil {
+		c.sendError(msg, protocol.ErrBadRequest, "invalid presence data")
+		return
+	}
+	status, err := room.ParsePresenceStatus(string(presence.Status))
+	if err != nil {
+		c.sendError(msg, protocol.ErrBadRequest, err.Error())
+		return
+	}
+
+	rm, exists := c.hub.roomManager.GetRoom(c.roomID)
+	if !exists {
+		c.sendError(msg, protocol.ErrNotFound, "room not found")
+		return
+	}
+	if user, changed := rm.SetPresence(c.userID, status); changed {
+		c.hub.publish(c.roomID, protocol.TypePresence, presenceOf(user))
+	}
+}
@@ -0,0 +1,177 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+
+	"synctunes/internal/protocol"
+	"synctunes/internal/room"
+
+	"github.com/gorilla/websocket"
+)
+
+// RoomHub fans messages out to the clients of one room. Its clients and
+// event log belong to its run goroutine.
+type RoomHub struct {
+	roomID      string
+	clients     map[*Client]bool
+	events      *eventLog // recent events, for clients that reconnect
+	roomManager *room.Manager
+	deliveries  chan delivery
+	register    chan *Client
+	unregister  chan *Client
+	done        chan struct{} // closed by the Hub once the room is empty, unless it was closed first
+	stopped     chan struct{} // closed once run has returned, and the event log is free
+	members     int           // clients registered; belongs to the Hub
+}
+
+func newRoomHub(roomID string, roomManager *room.Manager, events *eventLog) *RoomHub {
+	return &RoomHub{
+		roomID:      roomID,
+		clients:     make(map[*Client]bool),
+		events:      events,
+		roomManager: roomManager,
+		deliveries:  make(chan delivery, 256),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+}
+
+func (rh *RoomHub) run() {
+	defer close(rh.stopped)
+
+	for {
+		select {
+		case client := <-rh.register:
+			rh.clients[client] = true
+			log.Printf("Client connected to room %s as %s", rh.roomID, client.role)
+			// The client's own snapshot shows it online; everyone is told
+			// after it has caught up, so nobody sees the event twice
+			user, online := rh.connectPresence(client)
+			rh.catchUp(client)
+			if online {
+				rh.announcePresence(user)
+			}
+
+		case client := <-rh.unregister:
+			if _, ok := rh.clients[client]; ok {
+				delete(rh.clients, client)
+				client.close()
+				log.Printf("Client (%s) disconnected from room %s", client.role, rh.roomID)
+			}
+			// Every client unregisters exactly once, whether it left, was
+			// evicted or was dropped for being slow
+			rh.disconnectPresence(client)
+
+		case d := <-rh.deliveries:
+			rh.dispatch(d)
+			if d.closing {
+				rh.closeClients()
+				log.Printf("Closed hub for deleted room %s", rh.roomID)
+				return
+			}
+
+		case <-rh.done:
+			// Events already routed here still belong in the log
+			for {
+				select {
+				case d := <-rh.deliveries:
+					rh.dispatch(d)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (rh *RoomHub) dispatch(d delivery) {
+	if d.member != nil {
+		rh.changeMember(d.member)
+	}
+
+	if d.event == nil {
+		for client := range rh.clients {
+			if (d.role == "" || client.role == d.role) && (d.can == "" || room.UserRole(client.role).Can(d.can)) {
+				rh.deliver(client, d.msgType, d.message)
+			}
+		}
+		return
+	}
+
+	message := rh.events.record(d.event)
+	if message == nil {
+		return
+	}
+	for client := range rh.clients {
+		rh.deliver(client, d.event.Type, message)
+	}
+}
+
+// changeMember gives a member's clients their new role, or disconnects
+// them if they were kicked out.
+func (rh *RoomHub) changeMember(change *memberChange) {
+	for client := range rh.clients {
+		if client.userID != change.userID {
+			continue
+		}
+		if change.removed {
+			delete(rh.clients, client)
+			client.disconnect(websocket.ClosePolicyViolation, "removed from the room")
+			continue
+		}
+		client.role = change.role
+	}
+}
+
+// closeClients disconnects everyone once the room is gone, after they
+// have been sent what is already queued.
+func (rh *RoomHub) closeClients() {
+	for client := range rh.clients {
+		delete(rh.clients, client)
+		client.finish(websocket.CloseNormalClosure, "room closed")
+	}
+}
+
+// deliver queues a message for a client, dropping the client if it can't
+// keep up.
+func (rh *RoomHub) deliver(client *Client, msgType string, message []byte) {
+	if !client.queue(msgType, message) {
+		delete(rh.clients, client)
+	}
+}
+
+// catchUp brings a newly connected client up to date: a client resuming a
+// session gets the events it missed, if they are all still in the log, and
+// anyone else a snapshot of the room as of the latest event.
+func (rh *RoomHub) catchUp(client *Client) {
+	if client.resumeFrom > 0 {
+		if missed, ok := rh.events.since(client.resumeFrom); ok {
+			for _, event := range missed {
+				rh.deliver(client, event.msgType, event.message)
+			}
+			log.Printf("Replayed %d events to client resuming room %s from %d", len(missed), rh.roomID, client.resumeFrom)
+			return
+		}
+	}
+
+	rm, exists := rh.roomManager.GetRoom(rh.roomID)
+	if !exists {
+		return
+	}
+	env, err := protocol.NewEnvelope(protocol.TypeRoomState, protocol.RoomState(rm.GetState()))
+	if err != nil {
+		log.Printf("Error encoding room state: %v", err)
+		return
+	}
+	env.V = client.version
+	env.Seq = rh.events.last
+	message, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("Error encoding room state: %v", err)
+		return
+	}
+	rh.deliver(client, protocol.TypeRoomState, message)
+}